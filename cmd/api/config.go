@@ -1,24 +1,33 @@
 package main
 
 import (
+	"encoding/hex"
 	"flag"
 	"fmt"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
 
 type config struct {
-	port int
-	env  string
-	db   struct {
+	port    int
+	env     string
+	baseURL string
+	db      struct {
 		dsn          string
 		maxOpenConns int
 		maxIdleConns int
 		maxIdleTime  string
 	}
+	permissionsCache struct {
+		ttl time.Duration
+	}
+	softDelete struct {
+		retention time.Duration
+	}
 	limiter struct {
 		requestsPerSecond float64
 		bucket            int
@@ -35,8 +44,33 @@ type config struct {
 		setup     string
 		whiteList []string
 	}
+	auth struct {
+		mode            string
+		accessTokenTTL  time.Duration
+		refreshTokenTTL time.Duration
+		jwt             struct {
+			algorithm string
+			keyFile   string
+			issuer    string
+		}
+	}
+	totp struct {
+		encryptionKey []byte
+		issuer        string
+	}
+	lockout struct {
+		threshold   int
+		ipThreshold int
+		base        time.Duration
+		max         time.Duration
+	}
 }
 
+const (
+	authModeStateful = "stateful"
+	authModeJWT      = "jwt"
+)
+
 // init config, extract variables from command options (default values on .env variables)
 func initConfig() (*config, error) {
 	err := godotenv.Load()
@@ -79,16 +113,65 @@ func initConfig() (*config, error) {
 		return nil, err
 	}
 
+	permissionsCacheTTL, err := time.ParseDuration(os.Getenv("PERMISSIONS_CACHE_TTL"))
+	if err != nil {
+		return nil, err
+	}
+
+	softDeleteRetention, err := time.ParseDuration(os.Getenv("SOFT_DELETE_RETENTION"))
+	if err != nil {
+		return nil, err
+	}
+
+	accessTokenTTL, err := time.ParseDuration(os.Getenv("AUTH_ACCESS_TTL"))
+	if err != nil {
+		return nil, err
+	}
+
+	refreshTokenTTL, err := time.ParseDuration(os.Getenv("AUTH_REFRESH_TTL"))
+	if err != nil {
+		return nil, err
+	}
+
+	lockoutThreshold, err := strconv.Atoi(os.Getenv("LOCKOUT_THRESHOLD"))
+	if err != nil {
+		return nil, err
+	}
+
+	lockoutIPThreshold, err := strconv.Atoi(os.Getenv("LOCKOUT_IP_THRESHOLD"))
+	if err != nil {
+		return nil, err
+	}
+
+	lockoutBase, err := time.ParseDuration(os.Getenv("LOCKOUT_BASE"))
+	if err != nil {
+		return nil, err
+	}
+
+	lockoutMax, err := time.ParseDuration(os.Getenv("LOCKOUT_MAX"))
+	if err != nil {
+		return nil, err
+	}
+
 	var cfg config
 
+	cfg.totp.encryptionKey, err = hex.DecodeString(os.Getenv("TOTP_ENCRYPTION_KEY"))
+	if err != nil {
+		return nil, err
+	}
+
 	flag.IntVar(&cfg.port, "port", port, "API server port")
 	flag.StringVar(&cfg.env, "env", os.Getenv("ENV"), "Environment (development|staging|production)")
+	flag.StringVar(&cfg.baseURL, "base-url", os.Getenv("BASE_URL"), "Public URL of the API used to build absolute links, empty builds relative links")
 
 	flag.StringVar(&cfg.db.dsn, "db-dsn", os.Getenv("DB_DSN"), "PostgreSQL DSN")
 	flag.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", maxOpenConns, "PostgreSQL max open connections")
 	flag.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", maxIdleConns, "PostgreSQL max idle connections")
 	flag.StringVar(&cfg.db.maxIdleTime, "db-max-idle-time", os.Getenv("DB_MAXIDLETIME"), "PostgreSQL max connection idle time")
 
+	flag.DurationVar(&cfg.permissionsCache.ttl, "permissions-cache-ttl", permissionsCacheTTL, "Permissions cache time to live, 0 disables the cache")
+	flag.DurationVar(&cfg.softDelete.retention, "soft-delete-retention", softDeleteRetention, "Time deleted users and examples can be restored before they are purged, 0 disables the purge")
+
 	flag.BoolVar(&cfg.limiter.enabled, "limiter-enabled", enabled, "Enable rate limiter")
 	flag.Float64Var(&cfg.limiter.requestsPerSecond, "limiter-rps", requestsPerSecond, "Rate limiter requests per second regeneration")
 	flag.IntVar(&cfg.limiter.bucket, "limiter-bucket", bucket, "Rate limiter bucket capacity")
@@ -106,6 +189,25 @@ func initConfig() (*config, error) {
 		return nil
 	})
 
+	flag.StringVar(&cfg.auth.mode, "auth-mode", os.Getenv("AUTH_MODE"), "Authentication mode, stateful = tokens stored in db, jwt = signed JWTs")
+	flag.DurationVar(&cfg.auth.accessTokenTTL, "auth-access-ttl", accessTokenTTL, "Authentication (access) token time to live")
+	flag.DurationVar(&cfg.auth.refreshTokenTTL, "auth-refresh-ttl", refreshTokenTTL, "Refresh token time to live")
+
+	flag.StringVar(&cfg.auth.jwt.algorithm, "jwt-algorithm", os.Getenv("JWT_ALGORITHM"), "JWT signing algorithm (HS256|EdDSA)")
+	flag.StringVar(&cfg.auth.jwt.keyFile, "jwt-key-file", os.Getenv("JWT_KEY_FILE"), "JWT signing key file, HS256 secret or EdDSA PEM private key")
+	flag.StringVar(&cfg.auth.jwt.issuer, "jwt-issuer", os.Getenv("JWT_ISSUER"), "JWT issuer")
+
+	flag.Func("totp-encryption-key", "TOTP secrets encryption key (hex encoded, 32 bytes)", func(val string) error {
+		cfg.totp.encryptionKey, err = hex.DecodeString(val)
+		return err
+	})
+	flag.StringVar(&cfg.totp.issuer, "totp-issuer", os.Getenv("TOTP_ISSUER"), "TOTP issuer shown by authenticator apps")
+
+	flag.IntVar(&cfg.lockout.threshold, "lockout-threshold", lockoutThreshold, "Failed logins on an account before it is locked")
+	flag.IntVar(&cfg.lockout.ipThreshold, "lockout-ip-threshold", lockoutIPThreshold, "Failed logins from an ip before it is locked")
+	flag.DurationVar(&cfg.lockout.base, "lockout-base", lockoutBase, "First lockout duration, doubled on every new failure")
+	flag.DurationVar(&cfg.lockout.max, "lockout-max", lockoutMax, "Maximum lockout duration")
+
 	displayVersion := flag.Bool("version", false, "Display version and exit")
 
 	flag.Parse()
@@ -115,5 +217,13 @@ func initConfig() (*config, error) {
 		os.Exit(0)
 	}
 
+	if cfg.auth.mode != authModeStateful && cfg.auth.mode != authModeJWT {
+		return nil, fmt.Errorf("invalid auth mode %q, use %s or %s", cfg.auth.mode, authModeStateful, authModeJWT)
+	}
+
+	if len(cfg.totp.encryptionKey) != 32 {
+		return nil, fmt.Errorf("invalid TOTP encryption key, it must be 32 bytes long (64 hex characters)")
+	}
+
 	return &cfg, nil
 }
@@ -9,7 +9,11 @@ import (
 
 type contextKey string
 
-const userContextKey = contextKey("user")
+const (
+	userContextKey   = contextKey("user")
+	tokenContextKey  = contextKey("token")
+	apiKeyContextKey = contextKey("apiKey")
+)
 
 // save user info in context
 func (app *application) contextSetUser(r *http.Request, user *models.User) *http.Request {
@@ -25,3 +29,33 @@ func (app *application) contextGetUser(r *http.Request) *models.User {
 	}
 	return user
 }
+
+// save the authentication token plaintext used on the request in context
+func (app *application) contextSetToken(r *http.Request, token string) *http.Request {
+	ctx := context.WithValue(r.Context(), tokenContextKey, token)
+	return r.WithContext(ctx)
+}
+
+// get the authentication token plaintext from context
+func (app *application) contextGetToken(r *http.Request) string {
+	token, ok := r.Context().Value(tokenContextKey).(string)
+	if !ok {
+		panic("missing token value in request context")
+	}
+	return token
+}
+
+// save the api key used on the request in context
+func (app *application) contextSetAPIKey(r *http.Request, key *models.APIKey) *http.Request {
+	ctx := context.WithValue(r.Context(), apiKeyContextKey, key)
+	return r.WithContext(ctx)
+}
+
+// get the api key used on the request from context, nil if the request was not authenticated with an api key
+func (app *application) contextGetAPIKey(r *http.Request) *models.APIKey {
+	key, ok := r.Context().Value(apiKeyContextKey).(*models.APIKey)
+	if !ok {
+		return nil
+	}
+	return key
+}
@@ -4,8 +4,12 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
 	"net/http"
 	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // request errors
@@ -15,10 +19,17 @@ var errMethodNotAllowed = func(r *http.Request) error {
 	return fmt.Errorf("the %s method is not supported for this resource", r.Method)
 }
 var errWrongParameter = errors.New("the parameter is wrong")
+var errEmptyUpdate = errors.New("body must contain at least one field to update")
+var errUnsupportedMediaType = func(supported []string) error {
+	return fmt.Errorf("the Content-Type header must be one of: %s", strings.Join(supported, ", "))
+}
+var errInvalidIfMatch = errors.New("the If-Match header must contain the record version")
+var errPreconditionFailed = errors.New("the resource has been modified, the If-Match header does not match its current ETag")
 var errMaxBytesRequest = func(maxBytesError *http.MaxBytesError) error {
 	return fmt.Errorf("body must not be larger than %d bytes", maxBytesError.Limit)
 }
 var errRateLimit = errors.New("rate limit exceeded")
+var errTooManyLoginAttempts = errors.New("too many failed login attempts, try again later")
 
 // json errors
 var errJsonSyntax = func(syntaxError *json.SyntaxError) error {
@@ -64,3 +75,9 @@ func (app *application) clientError(w http.ResponseWriter, message any, status i
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 	}
 }
+
+// Send response to the user with status code 429, and the seconds to wait before a new login attempt
+func (app *application) loginLockedError(w http.ResponseWriter, lockedUntil time.Time) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(time.Until(lockedUntil).Seconds()))))
+	app.clientError(w, errTooManyLoginAttempts.Error(), http.StatusTooManyRequests)
+}
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"go.api.template/internal/models"
+	"go.api.template/internal/totp"
 	"go.api.template/internal/validator"
 )
 
@@ -42,13 +43,16 @@ func (app *application) createExampleHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	user := app.contextGetUser(r)
+
 	example := &models.Example{
 		ExampleValue1: input.ExampleValue1,
 		ExampleValue2: input.ExampleValue2,
 		ExampleValue3: input.ExampleValue3,
+		OwnerID:       &user.ID,
 	}
 
-	if v := example.ValidateExample(r); !v.Valid() {
+	if v := example.ValidateExample(); !v.Valid() {
 		app.clientError(w, v.Errors, http.StatusUnprocessableEntity)
 		return
 	}
@@ -76,7 +80,18 @@ func (app *application) showExampleHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	data, err := app.models.Examples.Get(id)
+	v := &validator.Validator{}
+
+	// sparse fieldset, only the selected fields are read and sent
+	fields := app.readCSV(r.URL.Query(), "fields", nil)
+	models.ExampleFieldSafelist.ValidateFields(fields, v)
+
+	if !v.Valid() {
+		app.clientError(w, v.Errors, http.StatusUnprocessableEntity)
+		return
+	}
+
+	example, err := app.models.Examples.GetFields(id, fields)
 	if err != nil {
 		if errors.Is(err, models.ErrExampleRecordNotFound) {
 			app.clientError(w, err.Error(), http.StatusNotFound)
@@ -87,14 +102,22 @@ func (app *application) showExampleHandler(w http.ResponseWriter, r *http.Reques
 		}
 	}
 
-	err = app.writeJSON(w, wrapperJson{"example": data}, http.StatusOK)
+	data, err := app.selectFields(example, fields)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// the ETag is the example version, so it does not depend on the fieldset
+	err = app.writeJSONWithETag(w, r, wrapperJson{"example": data}, versionETag(example.Version), http.StatusOK)
 	if err != nil {
 		app.serverError(w, err)
 		return
 	}
 }
 
-// Update example
+// Update example, only the owner can update it unless the user holds example:admin.
+// the body is a partial example (JSON or JSON Merge Patch) or a JSON Patch, no field can be removed.
 func (app *application) updateExampleHandler(w http.ResponseWriter, r *http.Request) {
 	id, err := app.readIDParam(r)
 	if err != nil || id < 1 {
@@ -102,35 +125,73 @@ func (app *application) updateExampleHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	var input struct {
-		ExampleValue1 float64 `json:"example_value_1"`
-		ExampleValue2 string  `json:"example_value_2"`
-		ExampleValue3 string  `json:"example_value_3"`
+	mediaType, ok := app.checkMediaType(w, r, "application/json", "application/merge-patch+json", "application/json-patch+json")
+	if !ok {
+		return
 	}
 
-	err = app.readJSON(w, r, &input)
+	ownerID, err := app.ownerScope(r, "example:admin")
 	if err != nil {
-		app.clientError(w, err.Error(), http.StatusBadRequest)
+		app.serverError(w, err)
 		return
 	}
 
-	example := &models.Example{
-		ExampleValue1: input.ExampleValue1,
-		ExampleValue2: input.ExampleValue2,
-		ExampleValue3: input.ExampleValue3,
+	current, ok := app.checkExampleOwner(w, id, ownerID)
+	if !ok {
+		return
+	}
+
+	// the client can send the version it based the changes on in the body, or as If-Match
+	if !app.checkIfMatch(w, r, current.Version) {
+		return
+	}
+
+	ifMatchVersion, _ := app.readIfMatchVersion(r)
+
+	var patch *models.ExamplePatch
+
+	if mediaType == "application/json-patch+json" {
+		patch, ok = app.readExampleJSONPatch(w, r, current)
+	} else {
+		patch, ok = app.readExampleMergePatch(w, r, current)
+	}
+
+	if !ok {
+		return
+	}
+
+	if v := patch.ValidateExamplePatch(); !v.Valid() {
+		app.clientError(w, v.Errors, http.StatusUnprocessableEntity)
+		return
 	}
 
-	if v := example.ValidateExample(r); !v.Valid() {
+	// apply the present fields to the stored example
+	example := current
+	patch.ApplyTo(example)
+
+	if v := example.ValidateExample(); !v.Valid() {
 		app.clientError(w, v.Errors, http.StatusUnprocessableEntity)
 		return
 	}
 
-	err = app.models.Examples.Update(example)
+	// Update only applies the changes on the expected version, a concurrent update is a conflict
+	if ifMatchVersion != nil {
+		example.Version = *ifMatchVersion
+	}
+
+	err = app.models.Examples.Update(example, ownerID)
 	if err != nil {
-		if errors.Is(err, models.ErrExampleRecordNotFound) {
+		switch {
+		case errors.Is(err, models.ErrEditConflict) && ifMatchVersion != nil:
+			app.clientError(w, errPreconditionFailed.Error(), http.StatusPreconditionFailed)
+			return
+		case errors.Is(err, models.ErrEditConflict):
+			app.clientError(w, err.Error(), http.StatusConflict)
+			return
+		case errors.Is(err, models.ErrExampleRecordNotFound):
 			app.clientError(w, err.Error(), http.StatusNotFound)
 			return
-		} else {
+		default:
 			app.serverError(w, err)
 			return
 		}
@@ -138,14 +199,14 @@ func (app *application) updateExampleHandler(w http.ResponseWriter, r *http.Requ
 
 	w.Header().Set("Location", fmt.Sprintf("/v1/example/%d", example.Id))
 
-	err = app.writeJSON(w, wrapperJson{"example": example}, http.StatusCreated)
+	err = app.writeJSONWithETag(w, r, wrapperJson{"example": example}, versionETag(example.Version), http.StatusCreated)
 	if err != nil {
 		app.serverError(w, err)
 		return
 	}
 }
 
-// Delete example
+// Delete example, only the owner can delete it unless the user holds example:admin
 func (app *application) deleteExampleHandler(w http.ResponseWriter, r *http.Request) {
 	id, err := app.readIDParam(r)
 	if err != nil || id < 1 {
@@ -153,7 +214,22 @@ func (app *application) deleteExampleHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	err = app.models.Examples.Delete(id)
+	ownerID, err := app.ownerScope(r, "example:admin")
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	current, ok := app.checkExampleOwner(w, id, ownerID)
+	if !ok {
+		return
+	}
+
+	if !app.checkIfMatch(w, r, current.Version) {
+		return
+	}
+
+	err = app.models.Examples.Delete(id, ownerID)
 	if err != nil {
 		if errors.Is(err, models.ErrExampleRecordNotFound) {
 			app.clientError(w, err.Error(), http.StatusNotFound)
@@ -172,42 +248,119 @@ func (app *application) deleteExampleHandler(w http.ResponseWriter, r *http.Requ
 
 }
 
-// Gets examples, search by filters
+// Restore a deleted example (admin)
+func (app *application) restoreExampleHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil || id < 1 {
+		app.clientError(w, errWrongParameter.Error(), http.StatusNotFound)
+		return
+	}
+
+	example, err := app.models.Examples.Restore(id)
+	if err != nil {
+		if errors.Is(err, models.ErrExampleRecordNotFound) {
+			app.clientError(w, err.Error(), http.StatusNotFound)
+			return
+		} else {
+			app.serverError(w, err)
+			return
+		}
+	}
+
+	err = app.writeJSON(w, wrapperJson{"example": example}, http.StatusOK)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+}
+
+// Gets examples, search by filters ("field[operator]=value", see models.ExampleFilterSafelist)
 func (app *application) listExamplesHandler(w http.ResponseWriter, r *http.Request) {
 
 	var input struct {
-		ExampleValue2 string
-		ExampleValue3 string
-		Filters       *models.Filters
+		Search  string
+		Owner   string
+		Deleted bool
+		Fields  []string
+		Filters *models.Filters
 	}
 
 	v := &validator.Validator{}
 
 	parameters := r.URL.Query()
 
-	input.ExampleValue2 = app.readString(parameters, "example_value_2", "")
-	input.ExampleValue3 = app.readString(parameters, "example_value_3", "")
+	input.Search = app.readString(parameters, "q", "")
+	input.Owner = app.readString(parameters, "owner", "")
+	v.Check(validator.PermittedValue(input.Owner, "", "me"), "owner", "invalid owner value, use me")
+	input.Deleted = app.readBool(parameters, "deleted", false, v)
+	// sparse fieldset, only the selected fields are read and sent
+	input.Fields = app.readCSV(parameters, "fields", nil)
+	models.ExampleFieldSafelist.ValidateFields(input.Fields, v)
 
 	page := app.readInt(parameters, "page", 1, v)
 	pageSize := app.readInt(parameters, "page_size", 20, v)
-	sort := app.readString(parameters, "sort", "id")
-	sortSafelist := []string{"id", "site"}
+	// a search is sorted by relevance unless other sort is requested
+	defaultSort := "id"
+	if input.Search != "" {
+		defaultSort = "-relevance"
+	}
+
+	sort := app.readString(parameters, "sort", defaultSort)
+	// relevance is the rank of the search, the other sort keys are columns
+	sortSafelist := append([]string{"relevance"}, models.ExampleSortColumns...)
 
 	input.Filters = models.InitFilters(page, pageSize, sort, sortSafelist)
+	input.Filters.After = app.readString(parameters, "after", "")
+	input.Filters.Before = app.readString(parameters, "before", "")
 	input.Filters.ValidateFilters(v)
+	v.Check(!input.Filters.SortedBy("relevance") || input.Search != "", "sort", "relevance can only be used with q")
+	input.Filters.ParseConditions(parameters, models.ExampleFilterSafelist, v)
 
 	if !v.Valid() {
 		app.clientError(w, v.Errors, http.StatusUnprocessableEntity)
 		return
 	}
 
-	data, metadata, err := app.models.Examples.GetAll(input.ExampleValue2, input.ExampleValue3, input.Filters)
+	// only example admins can list the deleted examples, to restore them
+	if input.Deleted {
+		admin, err := app.hasPermission(r, "example:admin")
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+
+		if !admin {
+			app.clientError(w, models.ErrNotPermitted.Error(), http.StatusForbidden)
+			return
+		}
+	}
+
+	// owner=me scopes the list to the examples of the request user
+	var ownerID int64
+	if input.Owner == "me" {
+		ownerID = app.contextGetUser(r).ID
+	}
+
+	examples, metadata, err := app.models.Examples.GetAll(input.Search, ownerID, input.Deleted, input.Fields, input.Filters)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidCursor) {
+			app.clientError(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		} else {
+			app.serverError(w, err)
+			return
+		}
+	}
+
+	data, err := app.selectFields(examples, input.Fields)
 	if err != nil {
 		app.serverError(w, err)
 		return
 	}
 
-	err = app.writeJSON(w, wrapperJson{"metadata": metadata, "examples": data}, http.StatusOK)
+	app.setPaginationLinks(w, r, metadata)
+
+	err = app.writeJSONWithETag(w, r, wrapperJson{"metadata": metadata, "examples": data}, "", http.StatusOK)
 	if err != nil {
 		app.serverError(w, err)
 		return
@@ -257,7 +410,7 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	err = app.models.Permissions.AddForUser(user.ID, "example:read")
+	err = app.models.Roles.AddForUser(user.ID, models.RoleUser)
 	if err != nil {
 		app.serverError(w, err)
 		return
@@ -328,7 +481,7 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	err = app.models.Users.UpdateField(token.UserID, "activated", true)
+	err = app.models.Users.Update(&models.User{ID: token.UserID, Activated: true}, "activated")
 	if err != nil {
 		if errors.Is(err, models.ErrUserRecordNotFound) {
 			app.clientError(w, err.Error(), http.StatusUnprocessableEntity)
@@ -369,9 +522,26 @@ func (app *application) authenticateUserHandler(w http.ResponseWriter, r *http.R
 		return
 	}
 
+	lockedUntil, err := app.loginLockedUntil(r, input.Email)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	if !lockedUntil.IsZero() {
+		app.loginLockedError(w, lockedUntil)
+		return
+	}
+
 	user, err := app.models.Users.GetByEmail(input.Email)
 	if err != nil {
 		if errors.Is(err, models.ErrUserRecordNotFound) {
+			err = app.recordLoginFailure(r, input.Email, nil)
+			if err != nil {
+				app.serverError(w, err)
+				return
+			}
+
 			app.clientError(w, models.ErrInvalidCredentials.Error(), http.StatusUnauthorized)
 			return
 		} else {
@@ -382,6 +552,7 @@ func (app *application) authenticateUserHandler(w http.ResponseWriter, r *http.R
 
 	if !user.Activated {
 		app.clientError(w, models.ErrInactiveUser.Error(), http.StatusUnauthorized)
+		return
 	}
 
 	match, err := user.Password.Matches(input.PlaintextPassword)
@@ -391,17 +562,1299 @@ func (app *application) authenticateUserHandler(w http.ResponseWriter, r *http.R
 	}
 
 	if !match {
+		err = app.recordLoginFailure(r, input.Email, user)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+
 		app.clientError(w, models.ErrInvalidCredentials.Error(), http.StatusUnauthorized)
 		return
 	}
 
-	token, err := app.models.Tokens.InitToken(user.ID, 24*time.Hour, models.ScopeAuthentication)
+	err = app.resetLoginFailures(r, input.Email)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	twoFactor, err := app.models.TOTP.Enabled(user.ID)
 	if err != nil {
 		app.serverError(w, err)
 		return
 	}
 
-	err = app.writeJSON(w, wrapperJson{"authentication_token": token}, http.StatusAccepted)
+	// enrolled users must complete the second step on POST /v1/users/authentication/totp
+	if twoFactor {
+		token, err := app.models.Tokens.InitToken(user.ID, 5*time.Minute, models.ScopeTwoFactor)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+
+		err = app.writeJSON(w, wrapperJson{"two_factor_token": token}, http.StatusAccepted)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+
+		return
+	}
+
+	family, err := models.NewTokenFamily()
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	tokens, err := app.createSessionTokens(user.ID, family)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	err = app.writeJSON(w, tokens, http.StatusAccepted)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+}
+
+// send a password reset token to the user email
+func (app *application) createPasswordResetTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.clientError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	v := validator.Validator{}
+	models.ValidateEmail(&v, input.Email)
+
+	if !v.Valid() {
+		app.clientError(w, v.Errors, http.StatusUnprocessableEntity)
+		return
+	}
+
+	user, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil {
+		if errors.Is(err, models.ErrUserRecordNotFound) {
+			v.AddError("email", "no matching email address found")
+			app.clientError(w, v.Errors, http.StatusUnprocessableEntity)
+			return
+		} else {
+			app.serverError(w, err)
+			return
+		}
+	}
+
+	if !user.Activated {
+		v.AddError("email", "user account must be activated")
+		app.clientError(w, v.Errors, http.StatusUnprocessableEntity)
+		return
+	}
+
+	token, err := app.models.Tokens.InitToken(user.ID, 45*time.Minute, models.ScopePasswordReset)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.backgroundFuncWithRecover(func() {
+		data := map[string]any{
+			"passwordResetToken": token.Plaintext,
+		}
+
+		err = app.mailer.Send(user.Email, "password_reset.tmpl", data)
+		if err != nil {
+			app.errorLog.Println(err.Error())
+		}
+	})
+
+	err = app.writeJSON(w, wrapperJson{"message": "an email will be sent to you containing password reset instructions"}, http.StatusAccepted)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+}
+
+// set a new user password using a password reset token
+func (app *application) updateUserPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Password       string `json:"password"`
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.clientError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	v := models.ValidateTokenPlaintext(input.TokenPlaintext)
+	models.ValidatePassword(v, input.Password)
+
+	if !v.Valid() {
+		app.clientError(w, v.Errors, http.StatusUnprocessableEntity)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(models.ScopePasswordReset, input.TokenPlaintext)
+	if err != nil {
+		if errors.Is(err, models.ErrRecordNotFound) {
+			app.clientError(w, models.ErrTokenRecordNotFoundOrExpiry.Error(), http.StatusUnprocessableEntity)
+			return
+		} else {
+			app.serverError(w, err)
+			return
+		}
+	}
+
+	err = user.Password.Set(input.Password)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	err = app.models.Users.Update(user, "password_hash")
+	if err != nil {
+		if errors.Is(err, models.ErrUserRecordNotFound) {
+			app.clientError(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		} else {
+			app.serverError(w, err)
+			return
+		}
+	}
+
+	// the reset token is single-use, and any session opened with the old password must end
+	err = app.models.Tokens.DeleteAllForUser(models.ScopePasswordReset, user.ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	err = app.revokeAllSessions(user.ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	err = app.writeJSON(w, wrapperJson{"message": "your password was successfully reset"}, http.StatusOK)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+}
+
+// send a new activation token to an user that has not been activated
+func (app *application) createActivationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.clientError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	v := validator.Validator{}
+	models.ValidateEmail(&v, input.Email)
+
+	if !v.Valid() {
+		app.clientError(w, v.Errors, http.StatusUnprocessableEntity)
+		return
+	}
+
+	user, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil {
+		if errors.Is(err, models.ErrUserRecordNotFound) {
+			v.AddError("email", "no matching email address found")
+			app.clientError(w, v.Errors, http.StatusUnprocessableEntity)
+			return
+		} else {
+			app.serverError(w, err)
+			return
+		}
+	}
+
+	if user.Activated {
+		v.AddError("email", "user has already been activated")
+		app.clientError(w, v.Errors, http.StatusUnprocessableEntity)
+		return
+	}
+
+	// only the latest activation token stays valid
+	err = app.models.Tokens.DeleteAllForUser(models.ScopeActivation, user.ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	token, err := app.models.Tokens.InitToken(user.ID, 3*24*time.Hour, models.ScopeActivation)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.backgroundFuncWithRecover(func() {
+		data := map[string]any{
+			"activationToken": token.Plaintext,
+			"userID":          user.ID,
+		}
+
+		err = app.mailer.Send(user.Email, "token_activation.tmpl", data)
+		if err != nil {
+			app.errorLog.Println(err.Error())
+		}
+	})
+
+	err = app.writeJSON(w, wrapperJson{"message": "an email will be sent to you containing activation instructions"}, http.StatusAccepted)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+}
+
+// revoke the authentication token used on the request and its refresh token (logout)
+func (app *application) deleteAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	token := app.contextGetToken(r)
+
+	var err error
+
+	if app.config.auth.mode == authModeJWT {
+		err = app.revokeJWT(token)
+	} else {
+		err = app.models.Tokens.DeleteWithFamily(models.ScopeAuthentication, token)
+	}
+	if err != nil {
+		if errors.Is(err, models.ErrTokenRecordNotFoundOrExpiry) {
+			app.clientError(w, models.ErrInvalidAuthenticationToken(w).Error(), http.StatusUnauthorized)
+			return
+		} else {
+			app.serverError(w, err)
+			return
+		}
+	}
+
+	err = app.writeJSON(w, wrapperJson{"message": "authentication token successfully revoked"}, http.StatusOK)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+}
+
+// revoke all the authentication and refresh tokens of the user (logout from every session)
+func (app *application) deleteAllAuthenticationTokensHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	err := app.revokeAllSessions(user.ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	err = app.writeJSON(w, wrapperJson{"message": "all authentication tokens successfully revoked"}, http.StatusOK)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+}
+
+// exchange a refresh token for a new authentication token and refresh token
+func (app *application) refreshAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"refresh_token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.clientError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if v := models.ValidateTokenPlaintext(input.TokenPlaintext); !v.Valid() {
+		app.clientError(w, v.Errors, http.StatusUnprocessableEntity)
+		return
+	}
+
+	token := &models.Token{
+		Plaintext: input.TokenPlaintext,
+		Hash:      models.HashToken(input.TokenPlaintext),
+		Scope:     models.ScopeRefresh,
+	}
+
+	err = app.models.Tokens.GetActiveToken(token)
+	if err != nil {
+		if errors.Is(err, models.ErrTokenRecordNotFoundOrExpiry) {
+			app.clientError(w, err.Error(), http.StatusUnauthorized)
+			return
+		} else {
+			app.serverError(w, err)
+			return
+		}
+	}
+
+	if !token.Used {
+		err = app.models.Tokens.MarkUsed(token)
+	} else {
+		err = models.ErrRefreshTokenReused
+	}
+
+	if err != nil {
+		// a rotated refresh token has been presented again, it could have been stolen, so the whole login is revoked
+		if errors.Is(err, models.ErrRefreshTokenReused) {
+			err = app.models.Tokens.DeleteFamily(token.Family)
+			if err != nil {
+				app.serverError(w, err)
+				return
+			}
+
+			app.clientError(w, models.ErrRefreshTokenReused.Error(), http.StatusUnauthorized)
+			return
+		} else {
+			app.serverError(w, err)
+			return
+		}
+	}
+
+	tokens, err := app.createSessionTokens(token.UserID, token.Family)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	err = app.writeJSON(w, tokens, http.StatusCreated)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+}
+
+// complete the authentication of an user with two-factor authentication enabled
+func (app *application) authenticateTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+		Code           string `json:"code"`
+		RecoveryCode   string `json:"recovery_code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.clientError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	v := models.ValidateTokenPlaintext(input.TokenPlaintext)
+	v.Check(validator.NotBlank(input.Code) != validator.NotBlank(input.RecoveryCode), "code", "must provide either a code or a recovery_code")
+
+	if !v.Valid() {
+		app.clientError(w, v.Errors, http.StatusUnprocessableEntity)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(models.ScopeTwoFactor, input.TokenPlaintext)
+	if err != nil {
+		if errors.Is(err, models.ErrRecordNotFound) {
+			app.clientError(w, models.ErrTokenRecordNotFoundOrExpiry.Error(), http.StatusUnauthorized)
+			return
+		} else {
+			app.serverError(w, err)
+			return
+		}
+	}
+
+	lockedUntil, err := app.loginLockedUntil(r, user.Email)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	if !lockedUntil.IsZero() {
+		app.loginLockedError(w, lockedUntil)
+		return
+	}
+
+	if input.RecoveryCode != "" {
+		err = app.models.TOTP.UseRecoveryCode(user.ID, models.HashToken(totp.NormalizeRecoveryCode(input.RecoveryCode)))
+	} else {
+		err = app.verifyTOTPCode(user.ID, input.Code)
+	}
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidTOTPCode) || errors.Is(err, models.ErrTOTPNotEnrolled) {
+			err = app.recordLoginFailure(r, user.Email, user)
+			if err != nil {
+				app.serverError(w, err)
+				return
+			}
+
+			app.clientError(w, models.ErrInvalidTOTPCode.Error(), http.StatusUnauthorized)
+			return
+		} else {
+			app.serverError(w, err)
+			return
+		}
+	}
+
+	err = app.models.Tokens.DeleteAllForUser(models.ScopeTwoFactor, user.ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	family, err := models.NewTokenFamily()
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	tokens, err := app.createSessionTokens(user.ID, family)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	err = app.writeJSON(w, tokens, http.StatusAccepted)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+}
+
+// start the two-factor authentication enrollment, generate a TOTP secret for the user
+func (app *application) createTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := app.models.Users.Get(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	encrypted, err := totp.Encrypt(app.config.totp.encryptionKey, secret)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	err = app.models.TOTP.Upsert(user.ID, encrypted)
+	if err != nil {
+		if errors.Is(err, models.ErrTOTPAlreadyEnabled) {
+			app.clientError(w, err.Error(), http.StatusConflict)
+			return
+		} else {
+			app.serverError(w, err)
+			return
+		}
+	}
+
+	data := wrapperJson{
+		"totp": map[string]any{
+			"secret":      secret,
+			"otpauth_uri": totp.URI(app.config.totp.issuer, user.Email, secret),
+		},
+	}
+
+	err = app.writeJSON(w, data, http.StatusCreated)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+}
+
+// confirm the two-factor authentication enrollment with a code, and return the recovery codes
+func (app *application) confirmTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Code string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.clientError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	v := validator.Validator{}
+	v.Check(validator.NotBlank(input.Code), "code", "must be provided")
+
+	if !v.Valid() {
+		app.clientError(w, v.Errors, http.StatusUnprocessableEntity)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	userTOTP, err := app.models.TOTP.Get(user.ID)
+	if err != nil {
+		if errors.Is(err, models.ErrTOTPNotEnrolled) {
+			app.clientError(w, err.Error(), http.StatusNotFound)
+			return
+		} else {
+			app.serverError(w, err)
+			return
+		}
+	}
+
+	if userTOTP.Confirmed {
+		app.clientError(w, models.ErrTOTPAlreadyEnabled.Error(), http.StatusConflict)
+		return
+	}
+
+	secret, err := totp.Decrypt(app.config.totp.encryptionKey, userTOTP.Secret)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	step, ok := totp.Validate(input.Code, secret, time.Now())
+	if !ok {
+		app.clientError(w, models.ErrInvalidTOTPCode.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	recoveryCodes, err := totp.GenerateRecoveryCodes(10)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	hashes := make([][]byte, len(recoveryCodes))
+	for i, code := range recoveryCodes {
+		hashes[i] = models.HashToken(code)
+	}
+
+	err = app.models.TOTP.Confirm(user.ID, step, hashes)
+	if err != nil {
+		if errors.Is(err, models.ErrTOTPAlreadyEnabled) {
+			app.clientError(w, err.Error(), http.StatusConflict)
+			return
+		} else {
+			app.serverError(w, err)
+			return
+		}
+	}
+
+	err = app.writeJSON(w, wrapperJson{"recovery_codes": recoveryCodes}, http.StatusOK)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+}
+
+// disable two-factor authentication, a valid code is required once the enrollment is confirmed
+func (app *application) deleteTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Code string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.clientError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	userTOTP, err := app.models.TOTP.Get(user.ID)
+	if err != nil {
+		if errors.Is(err, models.ErrTOTPNotEnrolled) {
+			app.clientError(w, err.Error(), http.StatusNotFound)
+			return
+		} else {
+			app.serverError(w, err)
+			return
+		}
+	}
+
+	if userTOTP.Confirmed {
+		err = app.verifyTOTPCode(user.ID, input.Code)
+		if err != nil {
+			if errors.Is(err, models.ErrInvalidTOTPCode) {
+				app.clientError(w, err.Error(), http.StatusUnprocessableEntity)
+				return
+			} else {
+				app.serverError(w, err)
+				return
+			}
+		}
+	}
+
+	err = app.models.TOTP.Delete(user.ID)
+	if err != nil {
+		if errors.Is(err, models.ErrTOTPNotEnrolled) {
+			app.clientError(w, err.Error(), http.StatusNotFound)
+			return
+		} else {
+			app.serverError(w, err)
+			return
+		}
+	}
+
+	err = app.writeJSON(w, wrapperJson{"message": "two-factor authentication successfully disabled"}, http.StatusOK)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+}
+
+// unlock an account locked after too many failed logins
+func (app *application) unlockUserHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.clientError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if v := models.ValidateTokenPlaintext(input.TokenPlaintext); !v.Valid() {
+		app.clientError(w, v.Errors, http.StatusUnprocessableEntity)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(models.ScopeUnlock, input.TokenPlaintext)
+	if err != nil {
+		if errors.Is(err, models.ErrRecordNotFound) {
+			app.clientError(w, models.ErrTokenRecordNotFoundOrExpiry.Error(), http.StatusUnprocessableEntity)
+			return
+		} else {
+			app.serverError(w, err)
+			return
+		}
+	}
+
+	_, err = app.models.LoginFailures.Reset(models.LoginFailureKeyForEmail(user.Email))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	err = app.models.Tokens.DeleteAllForUser(models.ScopeUnlock, user.ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	err = app.writeJSON(w, wrapperJson{"message": "your account has been unlocked"}, http.StatusOK)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+}
+
+// create a new api key for the user, the key is only shown in this response
+func (app *application) createAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name        string     `json:"name"`
+		Permissions []string   `json:"permissions"`
+		Expiry      *time.Time `json:"expiry"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.clientError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	key := &models.APIKey{
+		UserID:      user.ID,
+		Name:        input.Name,
+		Permissions: input.Permissions,
+		Expiry:      input.Expiry,
+	}
+
+	v := key.ValidateAPIKey()
+
+	err = app.validateAPIKeyPermissions(v, user.ID, key.Permissions)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	if !v.Valid() {
+		app.clientError(w, v.Errors, http.StatusUnprocessableEntity)
+		return
+	}
+
+	key, err = app.models.APIKeys.InitAPIKey(user.ID, key.Name, key.Permissions, key.Expiry)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/v1/users/me/api-keys/%d", key.ID))
+
+	err = app.writeJSON(w, wrapperJson{"api_key": key}, http.StatusCreated)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+}
+
+// list the api keys of the user
+func (app *application) listAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	keys, err := app.models.APIKeys.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	err = app.writeJSON(w, wrapperJson{"api_keys": keys}, http.StatusOK)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+}
+
+// view an api key of the user, search by id
+func (app *application) showAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil || id < 1 {
+		app.clientError(w, errWrongParameter.Error(), http.StatusNotFound)
+		return
+	}
+
+	key, err := app.models.APIKeys.Get(id, app.contextGetUser(r).ID)
+	if err != nil {
+		if errors.Is(err, models.ErrAPIKeyRecordNotFound) {
+			app.clientError(w, err.Error(), http.StatusNotFound)
+			return
+		} else {
+			app.serverError(w, err)
+			return
+		}
+	}
+
+	err = app.writeJSON(w, wrapperJson{"api_key": key}, http.StatusOK)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+}
+
+// update the name, permissions or expiry of an api key of the user
+func (app *application) updateAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil || id < 1 {
+		app.clientError(w, errWrongParameter.Error(), http.StatusNotFound)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	key, err := app.models.APIKeys.Get(id, user.ID)
+	if err != nil {
+		if errors.Is(err, models.ErrAPIKeyRecordNotFound) {
+			app.clientError(w, err.Error(), http.StatusNotFound)
+			return
+		} else {
+			app.serverError(w, err)
+			return
+		}
+	}
+
+	var input struct {
+		Name        *string    `json:"name"`
+		Permissions []string   `json:"permissions"`
+		Expiry      *time.Time `json:"expiry"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.clientError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if input.Name != nil {
+		key.Name = *input.Name
+	}
+	if input.Permissions != nil {
+		key.Permissions = input.Permissions
+	}
+	if input.Expiry != nil {
+		key.Expiry = input.Expiry
+	}
+
+	v := key.ValidateAPIKey()
+
+	err = app.validateAPIKeyPermissions(v, user.ID, key.Permissions)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	if !v.Valid() {
+		app.clientError(w, v.Errors, http.StatusUnprocessableEntity)
+		return
+	}
+
+	err = app.models.APIKeys.Update(key)
+	if err != nil {
+		if errors.Is(err, models.ErrAPIKeyRecordNotFound) {
+			app.clientError(w, err.Error(), http.StatusNotFound)
+			return
+		} else {
+			app.serverError(w, err)
+			return
+		}
+	}
+
+	err = app.writeJSON(w, wrapperJson{"api_key": key}, http.StatusOK)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+}
+
+// delete an api key of the user
+func (app *application) deleteAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil || id < 1 {
+		app.clientError(w, errWrongParameter.Error(), http.StatusNotFound)
+		return
+	}
+
+	err = app.models.APIKeys.Delete(id, app.contextGetUser(r).ID)
+	if err != nil {
+		if errors.Is(err, models.ErrAPIKeyRecordNotFound) {
+			app.clientError(w, err.Error(), http.StatusNotFound)
+			return
+		} else {
+			app.serverError(w, err)
+			return
+		}
+	}
+
+	err = app.writeJSON(w, wrapperJson{"message": fmt.Sprintf("api key %d successfully deleted", id)}, http.StatusOK)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+}
+
+// list users, search by filters (admin)
+func (app *application) listUsersHandler(w http.ResponseWriter, r *http.Request) {
+
+	var input struct {
+		Deleted bool
+		Filters *models.Filters
+	}
+
+	v := &validator.Validator{}
+
+	parameters := r.URL.Query()
+
+	input.Deleted = app.readBool(parameters, "deleted", false, v)
+
+	page := app.readInt(parameters, "page", 1, v)
+	pageSize := app.readInt(parameters, "page_size", 20, v)
+	sort := app.readString(parameters, "sort", "id")
+	input.Filters = models.InitFilters(page, pageSize, sort, models.UserSortColumns)
+	input.Filters.After = app.readString(parameters, "after", "")
+	input.Filters.Before = app.readString(parameters, "before", "")
+	input.Filters.ValidateFilters(v)
+	input.Filters.ParseConditions(parameters, models.UserFilterSafelist, v)
+
+	if !v.Valid() {
+		app.clientError(w, v.Errors, http.StatusUnprocessableEntity)
+		return
+	}
+
+	data, metadata, err := app.models.Users.GetAll(input.Deleted, input.Filters)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidCursor) {
+			app.clientError(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		} else {
+			app.serverError(w, err)
+			return
+		}
+	}
+
+	app.setPaginationLinks(w, r, metadata)
+
+	err = app.writeJSON(w, wrapperJson{"metadata": metadata, "users": data}, http.StatusOK)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+}
+
+// restore a deleted user (admin)
+func (app *application) restoreUserHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil || id < 1 {
+		app.clientError(w, errWrongParameter.Error(), http.StatusNotFound)
+		return
+	}
+
+	user, err := app.models.Users.Restore(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrUserRecordNotFound):
+			app.clientError(w, err.Error(), http.StatusNotFound)
+		case errors.Is(err, models.ErrDuplicateEmail):
+			app.clientError(w, err.Error(), http.StatusConflict)
+		default:
+			app.serverError(w, err)
+		}
+
+		return
+	}
+
+	err = app.writeJSON(w, wrapperJson{"user": user}, http.StatusOK)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+}
+
+// view the roles and permissions of an user (admin)
+func (app *application) showUserPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil || id < 1 {
+		app.clientError(w, errWrongParameter.Error(), http.StatusNotFound)
+		return
+	}
+
+	data, err := app.userPermissionsJSON(id)
+	if err != nil {
+		if errors.Is(err, models.ErrUserRecordNotFound) {
+			app.clientError(w, err.Error(), http.StatusNotFound)
+			return
+		} else {
+			app.serverError(w, err)
+			return
+		}
+	}
+
+	err = app.writeJSON(w, data, http.StatusOK)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+}
+
+// grant permissions to an user (admin)
+func (app *application) grantUserPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	app.changeUserPermissions(w, r, app.models.Permissions.AddForUser)
+}
+
+// revoke permissions granted directly to an user (admin), the ones granted by its roles are kept
+func (app *application) revokeUserPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	app.changeUserPermissions(w, r, app.models.Permissions.RemoveForUser)
+}
+
+// show the request user
+func (app *application) showCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := app.models.Users.Get(app.contextGetUser(r).ID)
+	if err != nil {
+		if errors.Is(err, models.ErrUserRecordNotFound) {
+			app.clientError(w, err.Error(), http.StatusNotFound)
+			return
+		} else {
+			app.serverError(w, err)
+			return
+		}
+	}
+
+	err = app.writeJSON(w, wrapperJson{"user": user}, http.StatusOK)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+}
+
+// update the name or the password of the request user, the current password is required to change the password.
+// changing the password revokes every session of the user.
+func (app *application) updateCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name            *string `json:"name"`
+		Password        *string `json:"password"`
+		CurrentPassword string  `json:"current_password"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.clientError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if input.Name == nil && input.Password == nil {
+		app.clientError(w, errEmptyUpdate.Error(), http.StatusBadRequest)
+		return
+	}
+
+	user, err := app.models.Users.Get(app.contextGetUser(r).ID)
+	if err != nil {
+		if errors.Is(err, models.ErrUserRecordNotFound) {
+			app.clientError(w, err.Error(), http.StatusNotFound)
+			return
+		} else {
+			app.serverError(w, err)
+			return
+		}
+	}
+
+	v := validator.Validator{}
+	fields := []string{}
+
+	if input.Name != nil {
+		models.ValidateName(&v, *input.Name)
+		user.Name = *input.Name
+		fields = append(fields, "name")
+	}
+
+	if input.Password != nil {
+		models.ValidatePassword(&v, *input.Password)
+		v.Check(validator.NotBlank(input.CurrentPassword), "current_password", "must be provided")
+	}
+
+	if !v.Valid() {
+		app.clientError(w, v.Errors, http.StatusUnprocessableEntity)
+		return
+	}
+
+	if input.Password != nil {
+		match, err := user.Password.Matches(input.CurrentPassword)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+
+		if !match {
+			app.clientError(w, models.ErrInvalidCredentials.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		err = user.Password.Set(*input.Password)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+
+		fields = append(fields, "password_hash")
+	}
+
+	err = app.models.Users.Update(user, fields...)
+	if err != nil {
+		if errors.Is(err, models.ErrUserRecordNotFound) {
+			app.clientError(w, err.Error(), http.StatusNotFound)
+			return
+		} else {
+			app.serverError(w, err)
+			return
+		}
+	}
+
+	if input.Password != nil {
+		err = app.revokeAllSessions(user.ID)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+	}
+
+	err = app.writeJSON(w, wrapperJson{"user": user}, http.StatusOK)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+}
+
+// delete the request user, the current password is required
+func (app *application) deleteCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Password string `json:"password"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.clientError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	v := validator.Validator{}
+	v.Check(validator.NotBlank(input.Password), "password", "must be provided")
+
+	if !v.Valid() {
+		app.clientError(w, v.Errors, http.StatusUnprocessableEntity)
+		return
+	}
+
+	user, err := app.models.Users.Get(app.contextGetUser(r).ID)
+	if err != nil {
+		if errors.Is(err, models.ErrUserRecordNotFound) {
+			app.clientError(w, err.Error(), http.StatusNotFound)
+			return
+		} else {
+			app.serverError(w, err)
+			return
+		}
+	}
+
+	match, err := user.Password.Matches(input.Password)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	if !match {
+		app.clientError(w, models.ErrInvalidCredentials.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	err = app.models.Users.Delete(user.ID)
+	if err != nil {
+		if errors.Is(err, models.ErrUserRecordNotFound) {
+			app.clientError(w, err.Error(), http.StatusNotFound)
+			return
+		} else {
+			app.serverError(w, err)
+			return
+		}
+	}
+
+	err = app.writeJSON(w, wrapperJson{"message": "your user account has been deleted"}, http.StatusOK)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+}
+
+// request a change of the request user email, a confirmation token is sent to the new email and a notice to the old one
+func (app *application) createEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.clientError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	v := validator.Validator{}
+	models.ValidateEmail(&v, input.Email)
+	v.Check(validator.NotBlank(input.Password), "password", "must be provided")
+
+	if !v.Valid() {
+		app.clientError(w, v.Errors, http.StatusUnprocessableEntity)
+		return
+	}
+
+	user, err := app.models.Users.Get(app.contextGetUser(r).ID)
+	if err != nil {
+		if errors.Is(err, models.ErrUserRecordNotFound) {
+			app.clientError(w, err.Error(), http.StatusNotFound)
+			return
+		} else {
+			app.serverError(w, err)
+			return
+		}
+	}
+
+	match, err := user.Password.Matches(input.Password)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	if !match {
+		app.clientError(w, models.ErrInvalidCredentials.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	_, err = app.models.Users.GetByEmail(input.Email)
+	switch {
+	case err == nil:
+		v.AddError("email", models.ErrDuplicateEmail.Error())
+		app.clientError(w, v.Errors, http.StatusUnprocessableEntity)
+		return
+	case !errors.Is(err, models.ErrUserRecordNotFound):
+		app.serverError(w, err)
+		return
+	}
+
+	token, err := app.models.Users.RequestEmailChange(user.ID, input.Email, 24*time.Hour)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.backgroundFuncWithRecover(func() {
+		data := map[string]any{
+			"emailChangeToken": token.Plaintext,
+			"userID":           user.ID,
+			"newEmail":         input.Email,
+		}
+
+		err := app.mailer.Send(input.Email, "email_change_confirm.tmpl", data)
+		if err != nil {
+			app.errorLog.Println(err.Error())
+		}
+
+		err = app.mailer.Send(user.Email, "email_change_notice.tmpl", data)
+		if err != nil {
+			app.errorLog.Println(err.Error())
+		}
+	})
+
+	err = app.writeJSON(w, wrapperJson{"message": "an email will be sent to the new address containing confirmation instructions"}, http.StatusAccepted)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+}
+
+// confirm an email change with the token sent to the new email
+func (app *application) confirmEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.clientError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if v := models.ValidateTokenPlaintext(input.TokenPlaintext); !v.Valid() {
+		app.clientError(w, v.Errors, http.StatusUnprocessableEntity)
+		return
+	}
+
+	user, err := app.models.Users.ConfirmEmailChange(input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrTokenRecordNotFoundOrExpiry),
+			errors.Is(err, models.ErrDuplicateEmail),
+			errors.Is(err, models.ErrUserRecordNotFound):
+			app.clientError(w, err.Error(), http.StatusUnprocessableEntity)
+		default:
+			app.serverError(w, err)
+		}
+
+		return
+	}
+
+	err = app.writeJSON(w, wrapperJson{"user": user}, http.StatusOK)
 	if err != nil {
 		app.serverError(w, err)
 		return
@@ -1,15 +1,28 @@
 package main
 
 import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"mime"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
+	"github.com/tomasen/realip"
+	"go.api.template/internal/jsonpatch"
+	"go.api.template/internal/jwt"
+	"go.api.template/internal/models"
+	"go.api.template/internal/totp"
 	"go.api.template/internal/validator"
 )
 
@@ -62,24 +75,271 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 	return i
 }
 
+// Reads a string value from the query string and converts it to a bool before returning.
+func (app *application) readBool(qs url.Values, key string, defaultValue bool, v *validator.Validator) bool {
+	s := qs.Get(key)
+
+	if s == "" {
+		return defaultValue
+	}
+
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		v.AddError(key, "must be a boolean value")
+		return defaultValue
+	}
+	return b
+}
+
 type wrapperJson map[string]any
 
 // Build json and send in a response
 func (app *application) writeJSON(w http.ResponseWriter, data wrapperJson, status int) error {
 
-	js, err := json.Marshal(data)
+	js, err := encodeJSON(data)
 	if err != nil {
 		return err
 	}
-	js = append(js, '\n')
 
+	sendJSON(w, js, status)
+
+	return nil
+}
+
+// encode the json body of a response
+func encodeJSON(data wrapperJson) ([]byte, error) {
+	js, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(js, '\n'), nil
+}
+
+// send an encoded json body
+func sendJSON(w http.ResponseWriter, js []byte, status int) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
 	w.Write(js)
+}
+
+// Check that the body media type (Content-Type header, JSON if not sent) is one of the supported and return it,
+// sending a 415 Unsupported Media Type response if not
+func (app *application) checkMediaType(w http.ResponseWriter, r *http.Request, supported ...string) (string, bool) {
+	mediaType := "application/json"
+
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		mediaType, _, _ = mime.ParseMediaType(contentType)
+	}
+
+	for _, value := range supported {
+		if mediaType == value {
+			return mediaType, true
+		}
+	}
+
+	if r.Method == http.MethodPatch {
+		w.Header().Set("Accept-Patch", strings.Join(supported, ", "))
+	}
+
+	app.clientError(w, errUnsupportedMediaType(supported).Error(), http.StatusUnsupportedMediaType)
+	return "", false
+}
+
+// Build json and send in a response with a strong ETag, the etag of the record version (see versionETag),
+// or if it is empty a hash of the body (for lists and resources without version).
+// GET and HEAD requests whose If-None-Match matches the ETag get a 304 Not Modified without body.
+func (app *application) writeJSONWithETag(w http.ResponseWriter, r *http.Request, data wrapperJson, etag string, status int) error {
+
+	js, err := encodeJSON(data)
+	if err != nil {
+		return err
+	}
+
+	if etag == "" {
+		etag = jsonETag(js)
+	}
+
+	w.Header().Set("ETag", etag)
+
+	if (r.Method == http.MethodGet || r.Method == http.MethodHead) && etagMatches(r.Header.Get("If-None-Match"), etag, true) {
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+
+	sendJSON(w, js, status)
 
 	return nil
 }
 
+// Set the navigation links of a list metadata and send them in a Link header (RFC 8288).
+// the links keep the query string of the request, only the page (or the cursor of the keyset pagination) changes.
+// they are absolute URLs when BASE_URL is configured, otherwise they are relative to the request URL.
+func (app *application) setPaginationLinks(w http.ResponseWriter, r *http.Request, metadata *models.Metadata) {
+	link := func(key, value string) string {
+		qs := r.URL.Query()
+		qs.Del("page")
+		qs.Del("after")
+		qs.Del("before")
+
+		if key != "" {
+			qs.Set(key, value)
+		}
+
+		// the request host is sent by the client, it cannot be trusted to build absolute links
+		link := strings.TrimSuffix(app.config.baseURL, "/") + r.URL.Path
+		if len(qs) == 0 {
+			return link
+		}
+
+		return link + "?" + qs.Encode()
+	}
+
+	metadata.First = link("", "")
+
+	switch {
+	// page number pagination, the number of pages is known
+	case metadata.CurrentPage > 0:
+		metadata.Last = link("page", strconv.Itoa(metadata.LastPage))
+
+		if metadata.CurrentPage < metadata.LastPage {
+			metadata.Next = link("page", strconv.Itoa(metadata.CurrentPage+1))
+		}
+
+		if metadata.CurrentPage > 1 {
+			metadata.Prev = link("page", strconv.Itoa(metadata.CurrentPage-1))
+		}
+	// keyset pagination, the pages are reached from the cursors
+	default:
+		if metadata.NextCursor != "" {
+			metadata.Next = link("after", metadata.NextCursor)
+		}
+
+		if metadata.PrevCursor != "" {
+			metadata.Prev = link("before", metadata.PrevCursor)
+		}
+	}
+
+	links := []string{}
+
+	for _, rel := range []struct{ name, url string }{
+		{"first", metadata.First},
+		{"prev", metadata.Prev},
+		{"next", metadata.Next},
+		{"last", metadata.Last},
+	} {
+		if rel.url != "" {
+			links = append(links, fmt.Sprintf(`<%s>; rel="%s"`, rel.url, rel.name))
+		}
+	}
+
+	w.Header().Set("Link", strings.Join(links, ", "))
+}
+
+// Keep only the selected fields (a sparse fieldset) of the JSON of an object or of each object of a list, no fields keeps all of them.
+// the data is marshalled first, so the fields built by a custom MarshalJSON can be selected too.
+func (app *application) selectFields(data any, fields []string) (any, error) {
+	if len(fields) == 0 {
+		return data, nil
+	}
+
+	js, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var single bool
+	var objects []map[string]json.RawMessage
+
+	if bytes.HasPrefix(js, []byte("[")) {
+		err = json.Unmarshal(js, &objects)
+	} else {
+		single = true
+		objects = make([]map[string]json.RawMessage, 1)
+		err = json.Unmarshal(js, &objects[0])
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, object := range objects {
+		for key := range object {
+			if !validator.PermittedValue(key, fields...) {
+				delete(object, key)
+			}
+		}
+	}
+
+	if single {
+		return objects[0], nil
+	}
+
+	return objects, nil
+}
+
+// Check the If-Match header against the version of the resource (its ETag, see versionETag),
+// sending a 400 response if it is not a version or a 412 Precondition Failed response if it does not match.
+// Requests without If-Match (or with "*") always pass.
+func (app *application) checkIfMatch(w http.ResponseWriter, r *http.Request, version int32) bool {
+	expected, err := app.readIfMatchVersion(r)
+	if err != nil {
+		app.clientError(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+
+	if expected != nil && *expected != version {
+		app.clientError(w, errPreconditionFailed.Error(), http.StatusPreconditionFailed)
+		return false
+	}
+
+	return true
+}
+
+// Read the record version sent as If-Match, the version ETag ("3") or the bare version (3), nil if it is not sent or is "*"
+func (app *application) readIfMatchVersion(r *http.Request) (*int32, error) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" || header == "*" {
+		return nil, nil
+	}
+
+	version, err := strconv.ParseInt(strings.Trim(header, `"`), 10, 32)
+	if err != nil {
+		return nil, errInvalidIfMatch
+	}
+
+	v := int32(version)
+	return &v, nil
+}
+
+// strong ETag of a record version, clients send it back as If-Match to update or delete that version
+func versionETag(version int32) string {
+	return fmt.Sprintf(`"%d"`, version)
+}
+
+// strong ETag of a json body
+func jsonETag(js []byte) string {
+	sum := sha256.Sum256(js)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// check if an If-Match or If-None-Match header ("*" or a comma separated list of ETags) matches the ETag.
+// If-None-Match uses the weak comparison (W/ prefixes are ignored), If-Match the strong one (weak ETags never match).
+func etagMatches(header string, etag string, weak bool) bool {
+	for _, value := range strings.Split(header, ",") {
+		value = strings.TrimSpace(value)
+
+		if weak {
+			value = strings.TrimPrefix(value, "W/")
+		}
+
+		if value == "*" || value == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
 // read json from a request
 func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any) error {
 
@@ -158,3 +418,463 @@ func (app *application) backgroundFuncWithRecover(fn func()) {
 		fn()
 	}()
 }
+
+// issue a short-lived authentication token and a long-lived refresh token that belong to the same token family
+// on jwt auth mode the authentication token is a signed JWT, the refresh token is always stored in db.
+func (app *application) createSessionTokens(userID int64, family []byte) (wrapperJson, error) {
+	var authenticationToken *models.Token
+	var err error
+
+	if app.config.auth.mode == authModeJWT {
+		authenticationToken, err = app.createJWT(userID, family)
+	} else {
+		authenticationToken, err = app.models.Tokens.InitFamilyToken(userID, app.config.auth.accessTokenTTL, models.ScopeAuthentication, family)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := app.models.Tokens.InitFamilyToken(userID, app.config.auth.refreshTokenTTL, models.ScopeRefresh, family)
+	if err != nil {
+		return nil, err
+	}
+
+	return wrapperJson{"authentication_token": authenticationToken, "refresh_token": refreshToken}, nil
+}
+
+// sign a JWT authentication token for the user
+func (app *application) createJWT(userID int64, family []byte) (*models.Token, error) {
+	randomBytes := make([]byte, 16)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	expiry := now.Add(app.config.auth.accessTokenTTL)
+
+	// the tokens issued up to the second the user tokens were denied are denied (iat has second precision),
+	// a token issued after the denial in that same second is dated on the next one
+	deniedAt, err := app.models.Denylist.UserDeniedAt(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	issuedAt := now.Unix()
+	if issuedAt <= deniedAt.Unix() {
+		issuedAt = deniedAt.Unix() + 1
+	}
+
+	claims := jwt.Claims{
+		Subject:   strconv.FormatInt(userID, 10),
+		IssuedAt:  issuedAt,
+		NotBefore: now.Unix(),
+		Expiry:    expiry.Unix(),
+		ID:        base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes),
+		Family:    hex.EncodeToString(family),
+	}
+
+	signed, err := app.jwt.Sign(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.Token{Plaintext: signed, UserID: userID, Expiry: time.Unix(claims.Expiry, 0)}, nil
+}
+
+// verify a JWT and build the user from its claims, without reading the users table.
+// only activated users can authenticate, so the user is marked as activated,
+// the tokens of the users deleted or deactivated after they were issued are in the denylist.
+func (app *application) userForJWT(token string) (*models.User, error) {
+	claims, err := app.jwt.Verify(token)
+	if err != nil {
+		return nil, err
+	}
+
+	userID, err := strconv.ParseInt(claims.Subject, 10, 64)
+	if err != nil {
+		return nil, jwt.ErrInvalidToken
+	}
+
+	denied, err := app.models.Denylist.Denied(claims.ID, userID, time.Unix(claims.IssuedAt, 0))
+	if err != nil {
+		return nil, err
+	}
+
+	if denied {
+		return nil, models.ErrRecordNotFound
+	}
+
+	return &models.User{ID: userID, Activated: true}, nil
+}
+
+// revoke every session of an user, its authentication and refresh tokens and, on jwt auth mode, the JWTs already issued
+func (app *application) revokeAllSessions(userID int64) error {
+	err := app.models.Tokens.DeleteAllSessionsForUser(userID)
+	if err != nil {
+		return err
+	}
+
+	if app.config.auth.mode == authModeJWT {
+		return app.models.Denylist.DenyUser(userID)
+	}
+
+	return nil
+}
+
+// deny a JWT until it expires and revoke the refresh tokens issued with it
+func (app *application) revokeJWT(token string) error {
+	claims, err := app.jwt.Verify(token)
+	if err != nil {
+		return err
+	}
+
+	err = app.models.Denylist.Insert(claims.ID, time.Unix(claims.Expiry, 0))
+	if err != nil {
+		return err
+	}
+
+	family, err := hex.DecodeString(claims.Family)
+	if err != nil || len(family) == 0 {
+		return err
+	}
+
+	return app.models.Tokens.DeleteFamily(family)
+}
+
+// check a TOTP code of a confirmed enrollment, a code is accepted only once
+func (app *application) verifyTOTPCode(userID int64, code string) error {
+	userTOTP, err := app.models.TOTP.Get(userID)
+	if err != nil {
+		return err
+	}
+
+	if !userTOTP.Confirmed {
+		return models.ErrTOTPNotEnrolled
+	}
+
+	secret, err := totp.Decrypt(app.config.totp.encryptionKey, userTOTP.Secret)
+	if err != nil {
+		return err
+	}
+
+	step, ok := totp.Validate(code, secret, time.Now())
+	if !ok {
+		return models.ErrInvalidTOTPCode
+	}
+
+	return app.models.TOTP.UseStep(userID, step)
+}
+
+// check if the account or the client ip are locked after too many failed logins, zero time if not
+func (app *application) loginLockedUntil(r *http.Request, email string) (time.Time, error) {
+	return app.models.LoginFailures.LockedUntil(
+		models.LoginFailureKeyForEmail(email),
+		models.LoginFailureKeyForIP(realip.FromRequest(r)))
+}
+
+// forget the failed logins of the account after a successful login, and forgive them to the client ip too,
+// so users behind a shared ip (NAT) do not get the ip locked by their own typos once they log in.
+// the failures of other accounts from the same ip are kept.
+func (app *application) resetLoginFailures(r *http.Request, email string) error {
+	failures, err := app.models.LoginFailures.Reset(models.LoginFailureKeyForEmail(email))
+	if err != nil {
+		return err
+	}
+
+	return app.models.LoginFailures.Forgive(models.LoginFailureKeyForIP(realip.FromRequest(r)), failures)
+}
+
+// failed logins older than a day are forgotten
+const loginFailureWindow = 24 * time.Hour
+
+// count a failed login for the account and the client ip.
+// when the account of an existing user gets locked an unlock token is sent to its email.
+func (app *application) recordLoginFailure(r *http.Request, email string, user *models.User) error {
+	_, _, err := app.models.LoginFailures.RecordFailure(models.LoginFailureKeyForIP(realip.FromRequest(r)),
+		loginFailureWindow, app.config.lockout.ipThreshold, app.config.lockout.base, app.config.lockout.max)
+	if err != nil {
+		return err
+	}
+
+	failures, _, err := app.models.LoginFailures.RecordFailure(models.LoginFailureKeyForEmail(email),
+		loginFailureWindow, app.config.lockout.threshold, app.config.lockout.base, app.config.lockout.max)
+	if err != nil {
+		return err
+	}
+
+	if user == nil || failures != app.config.lockout.threshold {
+		return nil
+	}
+
+	err = app.models.Tokens.DeleteAllForUser(models.ScopeUnlock, user.ID)
+	if err != nil {
+		return err
+	}
+
+	token, err := app.models.Tokens.InitToken(user.ID, 24*time.Hour, models.ScopeUnlock)
+	if err != nil {
+		return err
+	}
+
+	app.backgroundFuncWithRecover(func() {
+		data := map[string]any{
+			"unlockToken": token.Plaintext,
+		}
+
+		err := app.mailer.Send(user.Email, "account_unlock.tmpl", data)
+		if err != nil {
+			app.errorLog.Println(err.Error())
+		}
+	})
+
+	return nil
+}
+
+// check that the api key permissions are a subset of the owner permissions
+func (app *application) validateAPIKeyPermissions(v *validator.Validator, userID int64, permissions models.Permissions) error {
+	userPermissions, err := app.models.Permissions.GetAllForUser(userID)
+	if err != nil {
+		return err
+	}
+
+	for _, code := range permissions {
+		v.Check(userPermissions.Include(code), "permissions", fmt.Sprintf("%s is not a permission of your user account", code))
+	}
+
+	return nil
+}
+
+// build the response with the roles, the direct permissions and all the permissions of an user
+func (app *application) userPermissionsJSON(userID int64) (wrapperJson, error) {
+	user, err := app.models.Users.Get(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	roles, err := app.models.Roles.GetAllForUser(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	direct, err := app.models.Permissions.GetDirectForUser(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if permissions == nil {
+		permissions = models.Permissions{}
+	}
+
+	return wrapperJson{"user": user, "roles": roles, "direct_permissions": direct, "permissions": permissions}, nil
+}
+
+// read permission codes from the request body, and add or remove them from the user in the route
+func (app *application) changeUserPermissions(w http.ResponseWriter, r *http.Request, change func(int64, ...string) error) {
+	id, err := app.readIDParam(r)
+	if err != nil || id < 1 {
+		app.clientError(w, errWrongParameter.Error(), http.StatusNotFound)
+		return
+	}
+
+	var input struct {
+		Codes []string `json:"codes"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.clientError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	permissions, err := app.models.Permissions.GetAll()
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	v := validator.Validator{}
+	v.Check(len(input.Codes) > 0, "codes", "must contain at least one permission")
+	v.Check(validator.Unique(input.Codes), "codes", "must not contain duplicate values")
+	for _, code := range input.Codes {
+		v.Check(permissions.Include(code), "codes", fmt.Sprintf("%s is not a valid permission", code))
+	}
+
+	if !v.Valid() {
+		app.clientError(w, v.Errors, http.StatusUnprocessableEntity)
+		return
+	}
+
+	_, err = app.models.Users.Get(id)
+	if err != nil {
+		if errors.Is(err, models.ErrUserRecordNotFound) {
+			app.clientError(w, err.Error(), http.StatusNotFound)
+			return
+		} else {
+			app.serverError(w, err)
+			return
+		}
+	}
+
+	err = change(id, input.Codes...)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	data, err := app.userPermissionsJSON(id)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	err = app.writeJSON(w, data, http.StatusOK)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+}
+
+// check if the request user has an specific permission
+func (app *application) hasPermission(r *http.Request, code string) (bool, error) {
+	user := app.contextGetUser(r)
+
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		return false, err
+	}
+
+	// an api key is restricted to the subset of the owner permissions it was created with
+	if key := app.contextGetAPIKey(r); key != nil && !key.Permissions.Include(code) {
+		return false, nil
+	}
+
+	return permissions.Include(code), nil
+}
+
+// returns the owner id that scopes the queries of the request user, 0 (any owner) if the user holds the admin permission
+func (app *application) ownerScope(r *http.Request, adminPermission string) (int64, error) {
+	admin, err := app.hasPermission(r, adminPermission)
+	if err != nil {
+		return 0, err
+	}
+
+	if admin {
+		return 0, nil
+	}
+
+	return app.contextGetUser(r).ID, nil
+}
+
+// check that the example exists and belongs to ownerID (0 for any owner), sending the error response if not
+func (app *application) checkExampleOwner(w http.ResponseWriter, id int64, ownerID int64) (*models.Example, bool) {
+	example, err := app.models.Examples.Get(id)
+	if err != nil {
+		if errors.Is(err, models.ErrExampleRecordNotFound) {
+			app.clientError(w, err.Error(), http.StatusNotFound)
+		} else {
+			app.serverError(w, err)
+		}
+		return nil, false
+	}
+
+	if ownerID != 0 && !example.OwnedBy(ownerID) {
+		app.clientError(w, models.ErrNotPermitted.Error(), http.StatusForbidden)
+		return nil, false
+	}
+
+	return example, true
+}
+
+// read a partial example (JSON or JSON Merge Patch) from the request, sending the error response if it is not valid.
+// the version the client based the changes on can be sent in the body.
+func (app *application) readExampleMergePatch(w http.ResponseWriter, r *http.Request, current *models.Example) (*models.ExamplePatch, bool) {
+	var patch models.ExamplePatch
+
+	err := app.readJSON(w, r, &patch)
+	if err != nil {
+		app.clientError(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+
+	if patch.Empty() {
+		app.clientError(w, errEmptyUpdate.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+
+	if patch.Version != nil && *patch.Version != current.Version {
+		app.clientError(w, models.ErrEditConflict.Error(), http.StatusConflict)
+		return nil, false
+	}
+
+	return &patch, true
+}
+
+// read a JSON Patch from the request and apply it to the patchable fields of the example (and its version, to be used by test operations),
+// sending the error response if it cannot be applied. a failed test operation is a conflict.
+func (app *application) readExampleJSONPatch(w http.ResponseWriter, r *http.Request, current *models.Example) (*models.ExamplePatch, bool) {
+	var operations []jsonpatch.Operation
+
+	err := app.readJSON(w, r, &operations)
+	if err != nil {
+		app.clientError(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+
+	if len(operations) == 0 {
+		app.clientError(w, errEmptyUpdate.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+
+	document, err := json.Marshal(map[string]any{
+		"example_value_1": current.ExampleValue1,
+		"example_value_2": current.ExampleValue2,
+		"example_value_3": current.ExampleValue3,
+		"version":         current.Version,
+	})
+	if err != nil {
+		app.serverError(w, err)
+		return nil, false
+	}
+
+	patched, err := jsonpatch.Apply(document, operations)
+	if err != nil {
+		switch {
+		case errors.Is(err, jsonpatch.ErrTestFailed):
+			app.clientError(w, err.Error(), http.StatusConflict)
+		default:
+			app.clientError(w, err.Error(), http.StatusUnprocessableEntity)
+		}
+		return nil, false
+	}
+
+	var patch models.ExamplePatch
+
+	dec := json.NewDecoder(bytes.NewReader(patched))
+	dec.DisallowUnknownFields()
+
+	err = dec.Decode(&patch)
+	if err != nil {
+		app.clientError(w, err.Error(), http.StatusUnprocessableEntity)
+		return nil, false
+	}
+
+	v := validator.Validator{}
+	v.Check(patch.ExampleValue1.Set, "example_value_1", "must not be removed")
+	v.Check(patch.ExampleValue2.Set, "example_value_2", "must not be removed")
+	v.Check(patch.ExampleValue3.Set, "example_value_3", "must not be removed")
+	v.Check(patch.Version != nil && *patch.Version == current.Version, "version", "must not be changed")
+
+	if !v.Valid() {
+		app.clientError(w, v.Errors, http.StatusUnprocessableEntity)
+		return nil, false
+	}
+
+	return &patch, true
+}
@@ -3,9 +3,11 @@ package main
 import (
 	"log"
 	"sync"
+	"time"
 
 	_ "github.com/lib/pq"
 
+	"go.api.template/internal/jwt"
 	"go.api.template/internal/mailer"
 	"go.api.template/internal/models"
 	"go.api.template/internal/vcs"
@@ -21,7 +23,10 @@ type application struct {
 	infoLog  *log.Logger
 	models   models.ModelsDBConnections
 	mailer   mailer.Mailer
+	jwt      *jwt.Signer
 	wg       *sync.WaitGroup
+	// closed on the shutdown signal, to stop the background loops
+	shutdown chan struct{}
 }
 
 func main() {
@@ -39,17 +44,66 @@ func main() {
 	defer db.Close()
 	infoLog.Printf("database connection pool established")
 
-	initMetrics(db)
+	err = models.CheckSortColumns(db)
+	if err != nil {
+		errorLog.Fatal(err.Error())
+	}
+
+	var permissionsCache *models.PermissionsCache
+
+	if cfg.permissionsCache.ttl > 0 {
+		permissionsCache = models.NewPermissionsCache(cfg.permissionsCache.ttl)
+
+		err = permissionsCache.Listen(cfg.db.dsn, func(err error) {
+			errorLog.Println(err.Error())
+		})
+		if err != nil {
+			errorLog.Fatal(err.Error())
+		}
+		infoLog.Printf("permissions cache listening for changes")
+	}
+
+	var denylistCache *models.DenylistCache
+
+	// JWTs are verified without querying the db, the denylist is kept in memory
+	if cfg.auth.mode == authModeJWT {
+		denylistCache = models.NewDenylistCache(db, cfg.auth.accessTokenTTL)
+
+		err = denylistCache.Load()
+		if err != nil {
+			errorLog.Fatal(err.Error())
+		}
+
+		err = denylistCache.Listen(cfg.db.dsn, func(err error) {
+			errorLog.Println(err.Error())
+		})
+		if err != nil {
+			errorLog.Fatal(err.Error())
+		}
+		infoLog.Printf("jwt denylist cache listening for changes")
+	}
+
+	initMetrics(db, permissionsCache)
 
 	app := &application{
 		config:   cfg,
 		errorLog: errorLog,
 		infoLog:  infoLog,
-		models:   models.NewModelsDBConnections(db),
+		models:   models.NewModelsDBConnections(db, permissionsCache, denylistCache),
 		mailer:   mailer.InitMailer(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
 		wg:       &sync.WaitGroup{},
+		shutdown: make(chan struct{}),
+	}
+
+	if cfg.auth.mode == authModeJWT {
+		app.jwt, err = jwt.LoadSigner(cfg.auth.jwt.algorithm, cfg.auth.jwt.keyFile, cfg.auth.jwt.issuer)
+		if err != nil {
+			errorLog.Fatal(err.Error())
+		}
 	}
 
+	app.purgeExpired(time.Hour)
+
 	err = app.serve()
 	if err != nil {
 		errorLog.Fatal(err.Error())
@@ -6,6 +6,8 @@ import (
 	"net/http"
 	"runtime"
 	"time"
+
+	"go.api.template/internal/models"
 )
 
 type metricsResponseWriter struct {
@@ -45,7 +47,7 @@ func (mw *metricsResponseWriter) Unwrap() http.ResponseWriter {
 }
 
 // init expvar variables for show server metrics
-func initMetrics(db *sql.DB) {
+func initMetrics(db *sql.DB, permissionsCache *models.PermissionsCache) {
 	expvar.NewString("version").Set(version)
 
 	expvar.Publish("goroutines", expvar.Func(func() any {
@@ -56,6 +58,14 @@ func initMetrics(db *sql.DB) {
 		return db.Stats()
 	}))
 
+	expvar.Publish("permissions_cache_hits", expvar.Func(func() any {
+		return permissionsCache.Hits()
+	}))
+
+	expvar.Publish("permissions_cache_misses", expvar.Func(func() any {
+		return permissionsCache.Misses()
+	}))
+
 	expvar.Publish("timestamp", expvar.Func(func() any {
 		return time.Now().Unix()
 	}))
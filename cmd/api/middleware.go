@@ -13,6 +13,7 @@ import (
 	"github.com/tomasen/realip"
 	"golang.org/x/time/rate"
 
+	"go.api.template/internal/jwt"
 	"go.api.template/internal/models"
 )
 
@@ -91,23 +92,73 @@ func (app *application) rateLimit(next http.Handler) http.Handler {
 // authenticate the user if a Bearer token is given
 func (app *application) authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// This indicates to any caches that the response may vary based on the value of the Authorization header in the request.
+		// This indicates to any caches that the response may vary based on the value of the Authorization and X-API-Key headers in the request.
 		w.Header().Add("Vary", "Authorization")
+		w.Header().Add("Vary", "X-API-Key")
 
 		authorizationHeader := r.Header.Get("Authorization")
-		if authorizationHeader == "" {
+		apiKey := r.Header.Get("X-API-Key")
+
+		if authorizationHeader == "" && apiKey == "" {
 			r = app.contextSetUser(r, models.AnonymousUser)
 			next.ServeHTTP(w, r)
 			return
 		}
 
-		headerParts := strings.Split(authorizationHeader, " ")
-		if len(headerParts) != 2 || headerParts[0] != "Bearer" {
-			app.clientError(w, models.ErrInvalidAuthenticationToken(w).Error(), http.StatusUnauthorized)
+		token := ""
+
+		if authorizationHeader != "" {
+			headerParts := strings.Split(authorizationHeader, " ")
+			if len(headerParts) != 2 || headerParts[0] != "Bearer" {
+				app.clientError(w, models.ErrInvalidAuthenticationToken(w).Error(), http.StatusUnauthorized)
+				return
+			}
+
+			token = headerParts[1]
+		}
+
+		// api keys can also be sent as a Bearer token, they are recognized by their prefix
+		if apiKey == "" && strings.HasPrefix(token, models.APIKeyPrefix) {
+			apiKey = token
+		}
+
+		if apiKey != "" {
+			key, user, err := app.models.APIKeys.Authenticate(apiKey)
+			if err != nil {
+				switch {
+				case errors.Is(err, models.ErrInvalidAPIKey):
+					app.clientError(w, err.Error(), http.StatusUnauthorized)
+				default:
+					app.serverError(w, err)
+				}
+				return
+			}
+
+			r = app.contextSetUser(r, user)
+			r = app.contextSetAPIKey(r, key)
+
+			next.ServeHTTP(w, r)
 			return
 		}
 
-		token := headerParts[1]
+		if app.config.auth.mode == authModeJWT {
+			user, err := app.userForJWT(token)
+			if err != nil {
+				switch {
+				case errors.Is(err, jwt.ErrInvalidToken), errors.Is(err, jwt.ErrExpiredToken), errors.Is(err, models.ErrRecordNotFound):
+					app.clientError(w, models.ErrInvalidAuthenticationToken(w).Error(), http.StatusUnauthorized)
+				default:
+					app.serverError(w, err)
+				}
+				return
+			}
+
+			r = app.contextSetUser(r, user)
+			r = app.contextSetToken(r, token)
+
+			next.ServeHTTP(w, r)
+			return
+		}
 
 		if v := models.ValidateTokenPlaintext(token); !v.Valid() {
 			app.clientError(w, models.ErrInvalidAuthenticationToken(w).Error(), http.StatusUnauthorized)
@@ -126,6 +177,7 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		}
 
 		r = app.contextSetUser(r, user)
+		r = app.contextSetToken(r, token)
 
 		next.ServeHTTP(w, r)
 	})
@@ -146,6 +198,19 @@ func (app *application) requireAuthenticatenUser(next http.Handler) http.Handler
 	})
 }
 
+// Verify that the user is authenticated with a token, api keys cannot manage sessions, credentials or other api keys
+func (app *application) requireTokenAuthentication(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if app.contextGetAPIKey(r) != nil {
+			app.clientError(w, models.ErrAPIKeyNotAllowed.Error(), http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
 // Verify that the user is active
 func (app *application) requireActivatedUser(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -165,15 +230,13 @@ func (app *application) requireActivatedUser(next http.Handler) http.Handler {
 func (app *application) requirePermission(code string, next http.HandlerFunc) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
-		user := app.contextGetUser(r)
-
-		permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+		permitted, err := app.hasPermission(r, code)
 		if err != nil {
 			app.serverError(w, err)
 			return
 		}
 
-		if !permissions.Include(code) {
+		if !permitted {
 			app.clientError(w, models.ErrNotPermitted.Error(), http.StatusForbidden)
 			return
 		}
@@ -192,7 +255,7 @@ func (app *application) enableCORS(next http.Handler) http.Handler {
 			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
 
 				w.Header().Set("Access-Control-Allow-Methods", "OPTIONS, PUT, PATCH, DELETE")
-				w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+				w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, X-API-Key, If-Match, If-None-Match")
 
 				w.WriteHeader(http.StatusOK)
 				return
@@ -201,6 +264,7 @@ func (app *application) enableCORS(next http.Handler) http.Handler {
 
 		if app.config.cors.setup == "all" {
 			w.Header().Set("Access-Control-Allow-Origin", "*")
+			w.Header().Set("Access-Control-Expose-Headers", "ETag, Link")
 			ifPreflighRequest()
 		} else {
 			w.Header().Add("Vary", "Origin")
@@ -212,6 +276,7 @@ func (app *application) enableCORS(next http.Handler) http.Handler {
 				for _, value := range app.config.cors.whiteList {
 					if origin == value {
 						w.Header().Set("Access-Control-Allow-Origin", origin)
+						w.Header().Set("Access-Control-Expose-Headers", "ETag, Link")
 						ifPreflighRequest()
 
 						break
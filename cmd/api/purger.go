@@ -0,0 +1,63 @@
+package main
+
+import (
+	"time"
+)
+
+// periodically purge, in background, the expired rows: the users and examples deleted more than the soft delete retention ago
+// (if the retention is set) and the failed logins already forgotten.
+// it stops on the shutdown signal, the server waits for a running purge to complete
+func (app *application) purgeExpired(interval time.Duration) {
+	app.backgroundFuncWithRecover(func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			app.purge()
+
+			select {
+			case <-ticker.C:
+			case <-app.shutdown:
+				return
+			}
+		}
+	})
+}
+
+// permanently delete the expired rows
+func (app *application) purge() {
+	// catch any panic, the purger must keep running
+	defer func() {
+		if err := recover(); err != nil {
+			app.errorLog.Println(err)
+		}
+	}()
+
+	if app.config.softDelete.retention > 0 {
+		before := time.Now().Add(-app.config.softDelete.retention)
+
+		examples, err := app.models.Examples.Purge(before)
+		if err != nil {
+			app.errorLog.Println(err.Error())
+		}
+
+		users, err := app.models.Users.Purge(before)
+		if err != nil {
+			app.errorLog.Println(err.Error())
+		}
+
+		if examples > 0 || users > 0 {
+			app.infoLog.Printf("purged %d deleted examples and %d deleted users", examples, users)
+		}
+	}
+
+	// the keys of any email tried on the login, existing or not, would grow the table without bound
+	loginFailures, err := app.models.LoginFailures.Purge(loginFailureWindow)
+	if err != nil {
+		app.errorLog.Println(err.Error())
+	}
+
+	if loginFailures > 0 {
+		app.infoLog.Printf("purged %d expired login failure keys", loginFailures)
+	}
+}
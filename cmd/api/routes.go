@@ -20,7 +20,9 @@ func (app *application) routes() http.Handler {
 		app.clientError(w, errMethodNotAllowed(r).Error(), http.StatusMethodNotAllowed)
 	})
 
+	authenticated := alice.New(app.requireAuthenticatenUser, app.requireTokenAuthentication)
 	user := alice.New(app.requireAuthenticatenUser, app.requireActivatedUser)
+	session := user.Append(app.requireTokenAuthentication)
 
 	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.healthcheckHandler)
 
@@ -32,7 +34,40 @@ func (app *application) routes() http.Handler {
 
 	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
 	router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/unlocked", app.unlockUserHandler)
 	router.HandlerFunc(http.MethodPost, "/v1/users/authentication", app.authenticateUserHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/users/authentication/totp", app.authenticateTwoFactorHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/password", app.updateUserPasswordHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/email", app.confirmEmailChangeHandler)
+
+	router.Handler(http.MethodGet, "/v1/users/me", user.ThenFunc(app.showCurrentUserHandler))
+	router.Handler(http.MethodPatch, "/v1/users/me", session.ThenFunc(app.updateCurrentUserHandler))
+	router.Handler(http.MethodDelete, "/v1/users/me", session.ThenFunc(app.deleteCurrentUserHandler))
+	router.Handler(http.MethodPost, "/v1/users/me/email", session.ThenFunc(app.createEmailChangeHandler))
+
+	router.Handler(http.MethodPost, "/v1/users/me/totp", session.ThenFunc(app.createTOTPHandler))
+	router.Handler(http.MethodPut, "/v1/users/me/totp/confirm", session.ThenFunc(app.confirmTOTPHandler))
+	router.Handler(http.MethodDelete, "/v1/users/me/totp", session.ThenFunc(app.deleteTOTPHandler))
+
+	router.Handler(http.MethodGet, "/v1/users/me/api-keys", session.ThenFunc(app.listAPIKeysHandler))
+	router.Handler(http.MethodPost, "/v1/users/me/api-keys", session.ThenFunc(app.createAPIKeyHandler))
+	router.Handler(http.MethodGet, "/v1/users/me/api-keys/:id", session.ThenFunc(app.showAPIKeyHandler))
+	router.Handler(http.MethodPatch, "/v1/users/me/api-keys/:id", session.ThenFunc(app.updateAPIKeyHandler))
+	router.Handler(http.MethodDelete, "/v1/users/me/api-keys/:id", session.ThenFunc(app.deleteAPIKeyHandler))
+
+	router.Handler(http.MethodGet, "/v1/admin/users", user.Then(app.requirePermission("users:admin", app.listUsersHandler)))
+	router.Handler(http.MethodPut, "/v1/admin/users/:id/restored", user.Then(app.requirePermission("users:admin", app.restoreUserHandler)))
+	router.Handler(http.MethodGet, "/v1/admin/users/:id/permissions", user.Then(app.requirePermission("users:admin", app.showUserPermissionsHandler)))
+	router.Handler(http.MethodPost, "/v1/admin/users/:id/permissions", user.Then(app.requirePermission("users:admin", app.grantUserPermissionsHandler)))
+	router.Handler(http.MethodDelete, "/v1/admin/users/:id/permissions", user.Then(app.requirePermission("users:admin", app.revokeUserPermissionsHandler)))
+
+	router.Handler(http.MethodPut, "/v1/admin/examples/:id/restored", user.Then(app.requirePermission("example:admin", app.restoreExampleHandler)))
+
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/activation", app.createActivationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/password-reset", app.createPasswordResetTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/refresh", app.refreshAuthenticationTokenHandler)
+	router.Handler(http.MethodDelete, "/v1/tokens/authentication", authenticated.ThenFunc(app.deleteAuthenticationTokenHandler))
+	router.Handler(http.MethodDelete, "/v1/tokens/authentication/all", authenticated.ThenFunc(app.deleteAllAuthenticationTokensHandler))
 
 	// exposing metrics
 	router.Handler(http.MethodGet, "/debug/vars", expvar.Handler())
@@ -36,6 +36,7 @@ func (app *application) serve() error {
 		s := <-quit
 
 		app.infoLog.Printf("shutting down server, signal: %s", s.String())
+		close(app.shutdown)
 
 		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
 		defer cancel()
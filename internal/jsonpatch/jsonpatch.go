@@ -0,0 +1,255 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// JSON Patch (RFC 6902) operations supported by Apply
+const (
+	OpAdd     = "add"
+	OpRemove  = "remove"
+	OpReplace = "replace"
+	OpTest    = "test"
+)
+
+var (
+	ErrTestFailed           = errors.New("json patch test operation failed")
+	ErrInvalidPatch         = errors.New("invalid json patch")
+	ErrUnsupportedOperation = errors.New("unsupported json patch operation, use add, remove, replace or test")
+	ErrPathNotFound         = errors.New("json patch path not found")
+)
+
+type Operation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value,omitempty"`
+	From  string          `json:"from,omitempty"`
+}
+
+// apply the operations in order to a json document and return the patched document.
+// operations are all or nothing, if one fails the error is returned and the document is not patched.
+func Apply(document []byte, patch []Operation) ([]byte, error) {
+	var doc any
+
+	err := json.Unmarshal(document, &doc)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, operation := range patch {
+		doc, err = apply(doc, operation)
+		if err != nil {
+			return nil, fmt.Errorf("operation %d: %w", i, err)
+		}
+	}
+
+	return json.Marshal(doc)
+}
+
+// apply an operation, returns the new root of the document
+func apply(doc any, operation Operation) (any, error) {
+	path, err := parsePointer(operation.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	var value any
+
+	if operation.Op == OpAdd || operation.Op == OpReplace || operation.Op == OpTest {
+		if operation.Value == nil {
+			return nil, fmt.Errorf("%w: %s requires a value", ErrInvalidPatch, operation.Op)
+		}
+
+		err = json.Unmarshal(operation.Value, &value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidPatch, err.Error())
+		}
+	}
+
+	switch operation.Op {
+	case OpAdd:
+		return set(doc, path, value, true)
+
+	case OpReplace:
+		return set(doc, path, value, false)
+
+	case OpRemove:
+		return remove(doc, path)
+
+	case OpTest:
+		current, err := get(doc, path)
+		if err != nil {
+			return nil, err
+		}
+
+		if !equal(current, value) {
+			return nil, fmt.Errorf("%w: %s", ErrTestFailed, operation.Path)
+		}
+
+		return doc, nil
+
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedOperation, operation.Op)
+	}
+}
+
+// split a JSON Pointer (RFC 6901) in its unescaped reference tokens, "" is the whole document
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("%w: path %q must start with /", ErrInvalidPatch, pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+
+	return tokens, nil
+}
+
+// get the value a path points to
+func get(doc any, path []string) (any, error) {
+	for _, token := range path {
+		switch node := doc.(type) {
+		case map[string]any:
+			value, found := node[token]
+			if !found {
+				return nil, fmt.Errorf("%w: %s", ErrPathNotFound, token)
+			}
+			doc = value
+
+		case []any:
+			index, err := arrayIndex(token, len(node)-1)
+			if err != nil {
+				return nil, err
+			}
+			doc = node[index]
+
+		default:
+			return nil, fmt.Errorf("%w: %s", ErrPathNotFound, token)
+		}
+	}
+
+	return doc, nil
+}
+
+// set the value a path points to. add creates object members and inserts array elements ("-" appends),
+// replace requires the target to exist.
+func set(doc any, path []string, value any, add bool) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	parent, err := get(doc, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+
+	token := path[len(path)-1]
+
+	switch node := parent.(type) {
+	case map[string]any:
+		if _, found := node[token]; !found && !add {
+			return nil, fmt.Errorf("%w: %s", ErrPathNotFound, token)
+		}
+		node[token] = value
+		return doc, nil
+
+	case []any:
+		if !add {
+			index, err := arrayIndex(token, len(node)-1)
+			if err != nil {
+				return nil, err
+			}
+			node[index] = value
+			return doc, nil
+		}
+
+		index := len(node)
+		if token != "-" {
+			index, err = arrayIndex(token, len(node))
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		node = append(node[:index], append([]any{value}, node[index:]...)...)
+		return replaceParent(doc, path[:len(path)-1], node)
+
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrPathNotFound, token)
+	}
+}
+
+// remove the value a path points to
+func remove(doc any, path []string) (any, error) {
+	if len(path) == 0 {
+		return nil, fmt.Errorf("%w: the whole document cannot be removed", ErrInvalidPatch)
+	}
+
+	parent, err := get(doc, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+
+	token := path[len(path)-1]
+
+	switch node := parent.(type) {
+	case map[string]any:
+		if _, found := node[token]; !found {
+			return nil, fmt.Errorf("%w: %s", ErrPathNotFound, token)
+		}
+		delete(node, token)
+		return doc, nil
+
+	case []any:
+		index, err := arrayIndex(token, len(node)-1)
+		if err != nil {
+			return nil, err
+		}
+
+		node = append(node[:index:index], node[index+1:]...)
+		return replaceParent(doc, path[:len(path)-1], node)
+
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrPathNotFound, token)
+	}
+}
+
+// arrays change their length on add and remove, so the new array must be stored in its parent
+func replaceParent(doc any, path []string, array []any) (any, error) {
+	if len(path) == 0 {
+		return array, nil
+	}
+
+	return set(doc, path, array, false)
+}
+
+// parse an array index token, it must be between 0 and max
+func arrayIndex(token string, max int) (int, error) {
+	// leading zeros are not allowed by RFC 6901
+	if token == "" || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("%w: invalid array index %q", ErrPathNotFound, token)
+	}
+
+	index, err := strconv.Atoi(token)
+	if err != nil || index < 0 || index > max {
+		return 0, fmt.Errorf("%w: invalid array index %q", ErrPathNotFound, token)
+	}
+
+	return index, nil
+}
+
+// compare two decoded json values, numbers are compared by value
+func equal(a, b any) bool {
+	return reflect.DeepEqual(a, b)
+}
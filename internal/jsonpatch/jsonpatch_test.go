@@ -0,0 +1,262 @@
+package jsonpatch
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name  string
+		doc   string
+		patch string
+		want  string
+		err   error
+	}{
+		// RFC 6902 Appendix A
+		{
+			name:  "A.1 adding an object member",
+			doc:   `{"foo": "bar"}`,
+			patch: `[{"op": "add", "path": "/baz", "value": "qux"}]`,
+			want:  `{"baz": "qux", "foo": "bar"}`,
+		},
+		{
+			name:  "A.2 adding an array element",
+			doc:   `{"foo": ["bar", "baz"]}`,
+			patch: `[{"op": "add", "path": "/foo/1", "value": "qux"}]`,
+			want:  `{"foo": ["bar", "qux", "baz"]}`,
+		},
+		{
+			name:  "A.3 removing an object member",
+			doc:   `{"baz": "qux", "foo": "bar"}`,
+			patch: `[{"op": "remove", "path": "/baz"}]`,
+			want:  `{"foo": "bar"}`,
+		},
+		{
+			name:  "A.4 removing an array element",
+			doc:   `{"foo": ["bar", "qux", "baz"]}`,
+			patch: `[{"op": "remove", "path": "/foo/1"}]`,
+			want:  `{"foo": ["bar", "baz"]}`,
+		},
+		{
+			name:  "A.5 replacing a value",
+			doc:   `{"baz": "qux", "foo": "bar"}`,
+			patch: `[{"op": "replace", "path": "/baz", "value": "boo"}]`,
+			want:  `{"baz": "boo", "foo": "bar"}`,
+		},
+		{
+			name:  "A.6 moving a value is not supported",
+			doc:   `{"foo": {"bar": "baz", "waldo": "fred"}, "qux": {"corge": "grault"}}`,
+			patch: `[{"op": "move", "from": "/foo/waldo", "path": "/qux/thud"}]`,
+			err:   ErrUnsupportedOperation,
+		},
+		{
+			name:  "A.8 testing a value: success",
+			doc:   `{"baz": "qux", "foo": ["a", 2, "c"]}`,
+			patch: `[{"op": "test", "path": "/baz", "value": "qux"}, {"op": "test", "path": "/foo/1", "value": 2}]`,
+			want:  `{"baz": "qux", "foo": ["a", 2, "c"]}`,
+		},
+		{
+			name:  "A.9 testing a value: error",
+			doc:   `{"baz": "qux"}`,
+			patch: `[{"op": "test", "path": "/baz", "value": "bar"}]`,
+			err:   ErrTestFailed,
+		},
+		{
+			name:  "A.10 adding a nested member object",
+			doc:   `{"foo": "bar"}`,
+			patch: `[{"op": "add", "path": "/child", "value": {"grandchild": {}}}]`,
+			want:  `{"foo": "bar", "child": {"grandchild": {}}}`,
+		},
+		{
+			name:  "A.11 ignoring unrecognized elements",
+			doc:   `{"foo": "bar"}`,
+			patch: `[{"op": "add", "path": "/baz", "value": "qux", "xyz": 123}]`,
+			want:  `{"foo": "bar", "baz": "qux"}`,
+		},
+		{
+			name:  "A.12 adding to a nonexistent target",
+			doc:   `{"foo": "bar"}`,
+			patch: `[{"op": "add", "path": "/baz/bat", "value": "qux"}]`,
+			err:   ErrPathNotFound,
+		},
+		{
+			name:  "A.14 ~ escape ordering",
+			doc:   `{"/": 9, "~1": 10}`,
+			patch: `[{"op": "test", "path": "/~01", "value": 10}]`,
+			want:  `{"/": 9, "~1": 10}`,
+		},
+		{
+			name:  "A.15 comparing strings and numbers",
+			doc:   `{"/": 9, "~1": 10}`,
+			patch: `[{"op": "test", "path": "/~01", "value": "10"}]`,
+			err:   ErrTestFailed,
+		},
+		{
+			name:  "A.16 adding an array value",
+			doc:   `{"foo": ["bar"]}`,
+			patch: `[{"op": "add", "path": "/foo/-", "value": ["abc", "def"]}]`,
+			want:  `{"foo": ["bar", ["abc", "def"]]}`,
+		},
+		// pointers and array indexes
+		{
+			name:  "~1 unescapes to /",
+			doc:   `{"a/b": 1}`,
+			patch: `[{"op": "replace", "path": "/a~1b", "value": 2}]`,
+			want:  `{"a/b": 2}`,
+		},
+		{
+			name:  "~0 unescapes to ~",
+			doc:   `{"m~n": 1}`,
+			patch: `[{"op": "remove", "path": "/m~0n"}]`,
+			want:  `{}`,
+		},
+		{
+			name:  "add at the array length appends",
+			doc:   `[1, 2]`,
+			patch: `[{"op": "add", "path": "/2", "value": 3}]`,
+			want:  `[1, 2, 3]`,
+		},
+		{
+			name:  "add past the array length",
+			doc:   `[1, 2]`,
+			patch: `[{"op": "add", "path": "/3", "value": 3}]`,
+			err:   ErrPathNotFound,
+		},
+		{
+			name:  "replace at the array length",
+			doc:   `[1, 2]`,
+			patch: `[{"op": "replace", "path": "/2", "value": 3}]`,
+			err:   ErrPathNotFound,
+		},
+		{
+			name:  "remove past the array end",
+			doc:   `[1, 2]`,
+			patch: `[{"op": "remove", "path": "/2"}]`,
+			err:   ErrPathNotFound,
+		},
+		{
+			name:  "negative array index",
+			doc:   `[1, 2]`,
+			patch: `[{"op": "replace", "path": "/-1", "value": 3}]`,
+			err:   ErrPathNotFound,
+		},
+		{
+			name:  "array index with leading zeros",
+			doc:   `[1, 2]`,
+			patch: `[{"op": "replace", "path": "/01", "value": 3}]`,
+			err:   ErrPathNotFound,
+		},
+		{
+			name:  "- only appends on add",
+			doc:   `[1, 2]`,
+			patch: `[{"op": "replace", "path": "/-", "value": 3}]`,
+			err:   ErrPathNotFound,
+		},
+		{
+			name:  "replace the whole document",
+			doc:   `{"foo": "bar"}`,
+			patch: `[{"op": "replace", "path": "", "value": [1]}]`,
+			want:  `[1]`,
+		},
+		// invalid patches
+		{
+			name:  "replace a missing member",
+			doc:   `{"foo": "bar"}`,
+			patch: `[{"op": "replace", "path": "/baz", "value": "qux"}]`,
+			err:   ErrPathNotFound,
+		},
+		{
+			name:  "path without leading /",
+			doc:   `{"foo": "bar"}`,
+			patch: `[{"op": "remove", "path": "foo"}]`,
+			err:   ErrInvalidPatch,
+		},
+		{
+			name:  "add without value",
+			doc:   `{"foo": "bar"}`,
+			patch: `[{"op": "add", "path": "/baz"}]`,
+			err:   ErrInvalidPatch,
+		},
+		{
+			name:  "copy is not supported",
+			doc:   `{"foo": "bar"}`,
+			patch: `[{"op": "copy", "from": "/foo", "path": "/baz"}]`,
+			err:   ErrUnsupportedOperation,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var patch []Operation
+
+			err := json.Unmarshal([]byte(tt.patch), &patch)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := Apply([]byte(tt.doc), patch)
+
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("Apply() error = %v, want %v", err, tt.err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Apply() error = %v", err)
+			}
+
+			assertJSONEqual(t, got, tt.want)
+		})
+	}
+}
+
+// a failing operation discards the changes of the previous ones
+func TestApplyIsAllOrNothing(t *testing.T) {
+	doc := []byte(`{"foo": ["bar"], "baz": 1}`)
+	original := bytes.Clone(doc)
+
+	patch := []Operation{
+		{Op: OpAdd, Path: "/foo/-", Value: json.RawMessage(`"qux"`)},
+		{Op: OpRemove, Path: "/baz"},
+		{Op: OpTest, Path: "/foo/0", Value: json.RawMessage(`"nope"`)},
+	}
+
+	got, err := Apply(doc, patch)
+	if !errors.Is(err, ErrTestFailed) {
+		t.Fatalf("Apply() error = %v, want %v", err, ErrTestFailed)
+	}
+
+	if got != nil {
+		t.Errorf("Apply() = %s, want nil", got)
+	}
+
+	if !bytes.Equal(doc, original) {
+		t.Errorf("Apply() modified the document to %s", doc)
+	}
+}
+
+func assertJSONEqual(t *testing.T, got []byte, want string) {
+	t.Helper()
+
+	var gotValue, wantValue any
+
+	err := json.Unmarshal(got, &gotValue)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = json.Unmarshal([]byte(want), &wantValue)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(gotValue, wantValue) {
+		t.Errorf("Apply() = %s, want %s", got, want)
+	}
+}
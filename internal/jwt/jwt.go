@@ -0,0 +1,197 @@
+package jwt
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	AlgorithmHS256 = "HS256"
+	AlgorithmEdDSA = "EdDSA"
+)
+
+var (
+	ErrInvalidToken         = errors.New("invalid token")
+	ErrExpiredToken         = errors.New("token has expired")
+	ErrUnsupportedAlgorithm = errors.New("unsupported signing algorithm, use HS256 or EdDSA")
+	ErrInvalidKey           = errors.New("invalid signing key")
+)
+
+var encoding = base64.RawURLEncoding
+
+type header struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ"`
+}
+
+type Claims struct {
+	Subject   string `json:"sub"`
+	Issuer    string `json:"iss,omitempty"`
+	IssuedAt  int64  `json:"iat"`
+	NotBefore int64  `json:"nbf"`
+	Expiry    int64  `json:"exp"`
+	ID        string `json:"jti"`
+	Family    string `json:"fam,omitempty"`
+}
+
+type Signer struct {
+	algorithm  string
+	issuer     string
+	hmacKey    []byte
+	privateKey ed25519.PrivateKey
+	publicKey  ed25519.PublicKey
+}
+
+// init a Signer with the key stored in keyFile.
+// HS256 expects a secret of at least 32 bytes, EdDSA expects a PEM encoded PKCS #8 Ed25519 private key.
+func LoadSigner(algorithm, keyFile, issuer string) (*Signer, error) {
+	content, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	signer := &Signer{algorithm: algorithm, issuer: issuer}
+
+	switch algorithm {
+	case AlgorithmHS256:
+		secret := []byte(strings.TrimSpace(string(content)))
+		if len(secret) < 32 {
+			return nil, fmt.Errorf("%w: HS256 secret must be at least 32 bytes long", ErrInvalidKey)
+		}
+
+		signer.hmacKey = secret
+
+	case AlgorithmEdDSA:
+		block, _ := pem.Decode(content)
+		if block == nil {
+			return nil, fmt.Errorf("%w: EdDSA key must be PEM encoded", ErrInvalidKey)
+		}
+
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+
+		privateKey, ok := key.(ed25519.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("%w: EdDSA key must be an Ed25519 private key", ErrInvalidKey)
+		}
+
+		signer.privateKey = privateKey
+		signer.publicKey = privateKey.Public().(ed25519.PublicKey)
+
+	default:
+		return nil, ErrUnsupportedAlgorithm
+	}
+
+	return signer, nil
+}
+
+// build a signed token with the claims, the issuer is set by the Signer
+func (s *Signer) Sign(claims Claims) (string, error) {
+	claims.Issuer = s.issuer
+
+	headerJson, err := json.Marshal(header{Algorithm: s.algorithm, Type: "JWT"})
+	if err != nil {
+		return "", err
+	}
+
+	claimsJson, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := encoding.EncodeToString(headerJson) + "." + encoding.EncodeToString(claimsJson)
+
+	return signingInput + "." + encoding.EncodeToString(s.signature([]byte(signingInput))), nil
+}
+
+// check the token signature, issuer and validity window, and return its claims
+func (s *Signer) Verify(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+
+	headerJson, err := encoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	var h header
+	err = json.Unmarshal(headerJson, &h)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	// never let the token choose the algorithm, only the configured one is accepted
+	if h.Algorithm != s.algorithm {
+		return nil, ErrInvalidToken
+	}
+
+	signature, err := encoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if !s.verifySignature([]byte(parts[0]+"."+parts[1]), signature) {
+		return nil, ErrInvalidToken
+	}
+
+	claimsJson, err := encoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	var claims Claims
+	err = json.Unmarshal(claimsJson, &claims)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if claims.Issuer != s.issuer || claims.Subject == "" || claims.ID == "" {
+		return nil, ErrInvalidToken
+	}
+
+	now := time.Now().Unix()
+
+	if claims.NotBefore > now {
+		return nil, ErrInvalidToken
+	}
+
+	if claims.Expiry <= now {
+		return nil, ErrExpiredToken
+	}
+
+	return &claims, nil
+}
+
+// sign the input with the configured algorithm
+func (s *Signer) signature(signingInput []byte) []byte {
+	if s.algorithm == AlgorithmEdDSA {
+		return ed25519.Sign(s.privateKey, signingInput)
+	}
+
+	mac := hmac.New(sha256.New, s.hmacKey)
+	mac.Write(signingInput)
+	return mac.Sum(nil)
+}
+
+// check the signature of the input with the configured algorithm
+func (s *Signer) verifySignature(signingInput, signature []byte) bool {
+	if s.algorithm == AlgorithmEdDSA {
+		return ed25519.Verify(s.publicKey, signingInput, signature)
+	}
+
+	return hmac.Equal(s.signature(signingInput), signature)
+}
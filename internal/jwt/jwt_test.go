@@ -0,0 +1,250 @@
+package jwt
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSecret = "0123456789abcdef0123456789abcdef"
+
+// write a key file and load a Signer with it
+func newTestSigner(t *testing.T, algorithm, key, issuer string) *Signer {
+	t.Helper()
+
+	keyFile := filepath.Join(t.TempDir(), "key")
+
+	err := os.WriteFile(keyFile, []byte(key), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := LoadSigner(algorithm, keyFile, issuer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signer
+}
+
+// PEM encoded PKCS #8 Ed25519 private key
+func newTestEdDSAKey(t *testing.T) string {
+	t.Helper()
+
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+}
+
+func validClaims() Claims {
+	now := time.Now().Unix()
+
+	return Claims{Subject: "1", IssuedAt: now, NotBefore: now, Expiry: now + 60, ID: "jti", Family: "family"}
+}
+
+func TestSignVerifyRoundTrip(t *testing.T) {
+	tests := []struct {
+		name      string
+		algorithm string
+		key       string
+	}{
+		{"HS256", AlgorithmHS256, testSecret},
+		{"EdDSA", AlgorithmEdDSA, newTestEdDSAKey(t)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signer := newTestSigner(t, tt.algorithm, tt.key, "api")
+			claims := validClaims()
+
+			token, err := signer.Sign(claims)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := signer.Verify(token)
+			if err != nil {
+				t.Fatalf("Verify() error = %v", err)
+			}
+
+			claims.Issuer = "api"
+			if *got != claims {
+				t.Errorf("Verify() = %+v, want %+v", *got, claims)
+			}
+		})
+	}
+}
+
+func TestLoadSignerRejectsShortSecret(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "key")
+
+	err := os.WriteFile(keyFile, []byte("short"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = LoadSigner(AlgorithmHS256, keyFile, "api")
+	if !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("LoadSigner() error = %v, want %v", err, ErrInvalidKey)
+	}
+}
+
+func TestVerifyRejectsAlgorithmMismatch(t *testing.T) {
+	hs256 := newTestSigner(t, AlgorithmHS256, testSecret, "api")
+	eddsa := newTestSigner(t, AlgorithmEdDSA, newTestEdDSAKey(t), "api")
+
+	token, err := hs256.Sign(validClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := eddsa.Verify(token); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Verify() of an HS256 token by an EdDSA signer error = %v, want %v", err, ErrInvalidToken)
+	}
+
+	// an unsigned token with the "none" algorithm
+	header, _ := json.Marshal(header{Algorithm: "none", Type: "JWT"})
+	claims, _ := json.Marshal(validClaims())
+	none := encoding.EncodeToString(header) + "." + encoding.EncodeToString(claims) + "."
+
+	for _, signer := range []*Signer{hs256, eddsa} {
+		if _, err := signer.Verify(none); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("Verify() of an alg none token by %s error = %v, want %v", signer.algorithm, err, ErrInvalidToken)
+		}
+	}
+}
+
+func TestVerifyRejectsTamperedToken(t *testing.T) {
+	signer := newTestSigner(t, AlgorithmHS256, testSecret, "api")
+
+	token, err := signer.Sign(validClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parts := strings.Split(token, ".")
+
+	// flip the first signature character
+	signature := []byte(parts[2])
+	if signature[0] == 'A' {
+		signature[0] = 'B'
+	} else {
+		signature[0] = 'A'
+	}
+
+	// change the subject keeping the signature
+	claims := validClaims()
+	claims.Subject = "2"
+	claims.Issuer = "api"
+	claimsJson, _ := json.Marshal(claims)
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"signature", parts[0] + "." + parts[1] + "." + string(signature)},
+		{"claims", parts[0] + "." + encoding.EncodeToString(claimsJson) + "." + parts[2]},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := signer.Verify(tt.token); !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("Verify() error = %v, want %v", err, ErrInvalidToken)
+			}
+		})
+	}
+}
+
+func TestVerifyRejectsWrongIssuer(t *testing.T) {
+	signer := newTestSigner(t, AlgorithmHS256, testSecret, "api")
+	other := newTestSigner(t, AlgorithmHS256, testSecret, "other")
+
+	token, err := other.Sign(validClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := signer.Verify(token); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Verify() error = %v, want %v", err, ErrInvalidToken)
+	}
+}
+
+func TestVerifyValidityWindow(t *testing.T) {
+	signer := newTestSigner(t, AlgorithmHS256, testSecret, "api")
+	now := time.Now().Unix()
+
+	tests := []struct {
+		name      string
+		notBefore int64
+		expiry    int64
+		want      error
+	}{
+		{"not before in the future", now + 60, now + 120, ErrInvalidToken},
+		{"expires now", now - 60, now, ErrExpiredToken},
+		{"expired", now - 120, now - 60, ErrExpiredToken},
+		{"expires later", now - 60, now + 60, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := validClaims()
+			claims.NotBefore = tt.notBefore
+			claims.Expiry = tt.expiry
+
+			token, err := signer.Sign(claims)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := signer.Verify(token); !errors.Is(err, tt.want) {
+				t.Errorf("Verify() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyRejectsMalformedToken(t *testing.T) {
+	signer := newTestSigner(t, AlgorithmHS256, testSecret, "api")
+
+	token, err := signer.Sign(validClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parts := strings.Split(token, ".")
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"empty", ""},
+		{"one segment", parts[0]},
+		{"two segments", parts[0] + "." + parts[1]},
+		{"four segments", token + "." + parts[2]},
+		{"invalid base64", parts[0] + ".!!!." + parts[2]},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := signer.Verify(tt.token); !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("Verify() error = %v, want %v", err, ErrInvalidToken)
+			}
+		})
+	}
+}
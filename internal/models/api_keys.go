@@ -0,0 +1,313 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+
+	"go.api.template/internal/validator"
+)
+
+// every API key starts with this prefix, it lets the authenticate middleware tell them apart from tokens
+const APIKeyPrefix = "gat_"
+
+var (
+	ErrAPIKeyRecordNotFound = errors.New("api key record not found")
+	ErrInvalidAPIKey        = errors.New("invalid or expired api key")
+	ErrAPIKeyNotAllowed     = errors.New("this resource cannot be accessed with an api key")
+)
+
+type APIKey struct {
+	ID          int64       `json:"id"`
+	CreatedAt   time.Time   `json:"created_at"`
+	UserID      int64       `json:"-"`
+	Name        string      `json:"name"`
+	Prefix      string      `json:"prefix"`
+	Plaintext   string      `json:"key,omitempty"`
+	Hash        []byte      `json:"-"`
+	Permissions Permissions `json:"permissions"`
+	Expiry      *time.Time  `json:"expiry"`
+	LastUsedAt  *time.Time  `json:"last_used_at"`
+}
+
+type APIKeyDBConnection struct {
+	DB *sql.DB
+}
+
+// generate an API key instance, the plaintext is "gat_<prefix id>_<secret>" and only its hash is stored
+func generateAPIKey(userID int64, name string, permissions Permissions, expiry *time.Time) (*APIKey, error) {
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+
+	prefixBytes := make([]byte, 5)
+	_, err := rand.Read(prefixBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	secretBytes := make([]byte, 16)
+	_, err = rand.Read(secretBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := APIKeyPrefix + strings.ToLower(encoding.EncodeToString(prefixBytes))
+
+	key := &APIKey{
+		UserID:      userID,
+		Name:        name,
+		Prefix:      prefix,
+		Plaintext:   prefix + "_" + encoding.EncodeToString(secretBytes),
+		Permissions: permissions,
+		Expiry:      expiry,
+	}
+
+	key.Hash = HashToken(key.Plaintext)
+
+	return key, nil
+}
+
+// validate input api key fields
+func (k *APIKey) ValidateAPIKey() *validator.Validator {
+	v := validator.Validator{}
+
+	v.Check(validator.NotBlank(k.Name), "name", "must be provided")
+	v.Check(validator.MaxChars(k.Name, 100), "name", "must not be more than 100 bytes long")
+
+	v.Check(len(k.Permissions) > 0, "permissions", "must contain at least one permission")
+	v.Check(validator.Unique(k.Permissions), "permissions", "must not contain duplicate values")
+
+	if k.Expiry != nil {
+		v.Check(k.Expiry.After(time.Now()), "expiry", "must be in the future")
+	}
+
+	return &v
+}
+
+// generate and insert an api key in db
+func (a APIKeyDBConnection) InitAPIKey(userID int64, name string, permissions Permissions, expiry *time.Time) (*APIKey, error) {
+	key, err := generateAPIKey(userID, name, permissions, expiry)
+	if err != nil {
+		return nil, err
+	}
+
+	err = a.Insert(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// insert an api key in db
+func (a APIKeyDBConnection) Insert(key *APIKey) error {
+	query := `
+        INSERT INTO api_keys (user_id, name, prefix, hash, permissions, expiry)
+        VALUES ($1, $2, $3, $4, $5, $6)
+        RETURNING id, created_at`
+
+	args := []any{key.UserID, key.Name, key.Prefix, key.Hash, pq.Array([]string(key.Permissions)), key.Expiry}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return a.DB.QueryRowContext(ctx, query, args...).Scan(&key.ID, &key.CreatedAt)
+}
+
+// get an api key of an user
+func (a APIKeyDBConnection) Get(id int64, userID int64) (*APIKey, error) {
+	query := `
+        SELECT id, created_at, user_id, name, prefix, permissions, expiry, last_used_at
+        FROM api_keys
+        WHERE id = $1 AND user_id = $2`
+
+	var key APIKey
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := a.DB.QueryRowContext(ctx, query, id, userID).Scan(
+		&key.ID,
+		&key.CreatedAt,
+		&key.UserID,
+		&key.Name,
+		&key.Prefix,
+		pq.Array((*[]string)(&key.Permissions)),
+		&key.Expiry,
+		&key.LastUsedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrAPIKeyRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &key, nil
+}
+
+// get all the api keys of an user
+func (a APIKeyDBConnection) GetAllForUser(userID int64) ([]*APIKey, error) {
+	query := `
+        SELECT id, created_at, user_id, name, prefix, permissions, expiry, last_used_at
+        FROM api_keys
+        WHERE user_id = $1
+        ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := a.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	keys := []*APIKey{}
+
+	for rows.Next() {
+		var key APIKey
+
+		err := rows.Scan(
+			&key.ID,
+			&key.CreatedAt,
+			&key.UserID,
+			&key.Name,
+			&key.Prefix,
+			pq.Array((*[]string)(&key.Permissions)),
+			&key.Expiry,
+			&key.LastUsedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, &key)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// update the name, permissions and expiry of an api key
+func (a APIKeyDBConnection) Update(key *APIKey) error {
+	query := `
+        UPDATE api_keys
+        SET name = $1, permissions = $2, expiry = $3
+        WHERE id = $4 AND user_id = $5`
+
+	args := []any{key.Name, pq.Array([]string(key.Permissions)), key.Expiry, key.ID, key.UserID}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := a.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrAPIKeyRecordNotFound
+	}
+
+	return nil
+}
+
+// delete an api key of an user
+func (a APIKeyDBConnection) Delete(id int64, userID int64) error {
+	query := `
+        DELETE FROM api_keys
+        WHERE id = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := a.DB.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrAPIKeyRecordNotFound
+	}
+
+	return nil
+}
+
+// get an active api key by its plaintext together with its owner, and record its use
+func (a APIKeyDBConnection) Authenticate(plaintext string) (*APIKey, *User, error) {
+	query := `
+        SELECT api_keys.id, api_keys.created_at, api_keys.user_id, api_keys.name, api_keys.prefix,
+            api_keys.permissions, api_keys.expiry, api_keys.last_used_at,
+            users.id, users.created_at, users.name, users.email, users.password_hash, users.activated
+        FROM api_keys
+        INNER JOIN users ON users.id = api_keys.user_id
+        WHERE api_keys.hash = $1
+        AND (api_keys.expiry IS NULL OR api_keys.expiry > $2)
+        AND users.deleted_at IS NULL`
+
+	var key APIKey
+	var user User
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := a.DB.QueryRowContext(ctx, query, HashToken(plaintext), time.Now()).Scan(
+		&key.ID,
+		&key.CreatedAt,
+		&key.UserID,
+		&key.Name,
+		&key.Prefix,
+		pq.Array((*[]string)(&key.Permissions)),
+		&key.Expiry,
+		&key.LastUsedAt,
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil, ErrInvalidAPIKey
+		default:
+			return nil, nil, err
+		}
+	}
+
+	// last use is recorded with a minute precision, to avoid a write on every request
+	query = `
+        UPDATE api_keys
+        SET last_used_at = NOW()
+        WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute')`
+
+	_, err = a.DB.ExecContext(ctx, query, key.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &key, &user, nil
+}
@@ -7,22 +7,35 @@ import (
 	"time"
 )
 
-var ErrRecordNotFound = errors.New("record not found")
+var (
+	ErrRecordNotFound = errors.New("record not found")
+	ErrEditConflict   = errors.New("unable to update the record due to an edit conflict, please try again")
+)
 
 type ModelsDBConnections struct {
-	Examples    ExampleDBConnection
-	Users       UserDBConnection
-	Tokens      TokenDBConnection
-	Permissions PermissionsDBConnection
+	Examples      ExampleDBConnection
+	Users         UserDBConnection
+	Tokens        TokenDBConnection
+	Permissions   PermissionsDBConnection
+	Denylist      DenylistDBConnection
+	TOTP          TOTPDBConnection
+	LoginFailures LoginFailuresDBConnection
+	APIKeys       APIKeyDBConnection
+	Roles         RolesDBConnection
 }
 
-// init all db connections pools for the models
-func NewModelsDBConnections(db *sql.DB) ModelsDBConnections {
+// init all db connections pools for the models, permissionsCache and denylistCache can be nil to disable the caches
+func NewModelsDBConnections(db *sql.DB, permissionsCache *PermissionsCache, denylistCache *DenylistCache) ModelsDBConnections {
 	return ModelsDBConnections{
-		Examples:    ExampleDBConnection{DB: db},
-		Users:       UserDBConnection{DB: db},
-		Tokens:      TokenDBConnection{DB: db},
-		Permissions: PermissionsDBConnection{DB: db},
+		Examples:      ExampleDBConnection{DB: db},
+		Users:         UserDBConnection{DB: db},
+		Tokens:        TokenDBConnection{DB: db},
+		Permissions:   PermissionsDBConnection{DB: db, Cache: permissionsCache},
+		Denylist:      DenylistDBConnection{DB: db, Cache: denylistCache},
+		TOTP:          TOTPDBConnection{DB: db},
+		LoginFailures: LoginFailuresDBConnection{DB: db},
+		APIKeys:       APIKeyDBConnection{DB: db},
+		Roles:         RolesDBConnection{DB: db, Cache: permissionsCache},
 	}
 }
 
@@ -0,0 +1,110 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+type DenylistDBConnection struct {
+	DB    *sql.DB
+	Cache *DenylistCache
+}
+
+// deny a JWT by its id until it expires, entries that already expired are purged
+func (d DenylistDBConnection) Insert(jti string, expiry time.Time) error {
+	query := `
+        INSERT INTO jwt_denylist (jti, expiry)
+        VALUES ($1, $2)
+        ON CONFLICT (jti) DO NOTHING`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := d.DB.ExecContext(ctx, query, jti, expiry)
+	if err != nil {
+		return err
+	}
+
+	query = `
+        DELETE FROM jwt_denylist
+        WHERE expiry < $1`
+
+	_, err = d.DB.ExecContext(ctx, query, time.Now())
+	if err != nil {
+		return err
+	}
+
+	// the other instances reload their cache when notified, this one denies the token right away
+	d.Cache.add(jti, expiry)
+
+	return nil
+}
+
+// deny the JWTs of an user issued until now (logout from every session, password change).
+// the db trigger notifies the other instances, this one denies the tokens right away
+func (d DenylistDBConnection) DenyUser(userID int64) error {
+	query := `
+        INSERT INTO jwt_user_denylist (user_id, denied_at)
+        VALUES ($1, NOW())
+        ON CONFLICT (user_id) DO UPDATE SET denied_at = EXCLUDED.denied_at
+        RETURNING denied_at`
+
+	var deniedAt time.Time
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := d.DB.QueryRowContext(ctx, query, userID).Scan(&deniedAt)
+	if err != nil {
+		return err
+	}
+
+	d.Cache.denyUser(userID, deniedAt)
+
+	return nil
+}
+
+// get the time the JWTs of an user were last denied, zero time if they never were.
+// it always reads the db, the cache of this instance could not have been notified yet
+func (d DenylistDBConnection) UserDeniedAt(userID int64) (time.Time, error) {
+	query := `
+        SELECT denied_at
+        FROM jwt_user_denylist
+        WHERE user_id = $1`
+
+	var deniedAt time.Time
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := d.DB.QueryRowContext(ctx, query, userID).Scan(&deniedAt)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, err
+	}
+
+	return deniedAt, nil
+}
+
+// check if a JWT has been denied, by its id or because its user has been denied after issuedAt
+// (deleted, deactivated, logged out from every session or changed its password).
+// with a cache the check does not query the db.
+func (d DenylistDBConnection) Denied(jti string, userID int64, issuedAt time.Time) (bool, error) {
+	if d.Cache != nil {
+		return d.Cache.denied(jti, userID, issuedAt), nil
+	}
+
+	query := `
+        SELECT EXISTS(SELECT 1 FROM jwt_denylist WHERE jti = $1)
+        OR EXISTS(SELECT 1 FROM jwt_user_denylist WHERE user_id = $2 AND denied_at >= $3)`
+
+	var denied bool
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := d.DB.QueryRowContext(ctx, query, jti, userID, issuedAt).Scan(&denied)
+
+	return denied, err
+}
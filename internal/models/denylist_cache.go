@@ -0,0 +1,165 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// channel notified by the db triggers when a JWT or the JWTs of an user are denied
+const jwtDenylistChangedChannel = "jwt_denylist_changed"
+
+// in-process copy of the JWT denylist, so verifying a JWT does not query the db.
+// the denylist only holds the tokens that have not expired yet, so it stays small.
+type DenylistCache struct {
+	db *sql.DB
+	// the longest a JWT lives, older user denials cannot deny any token
+	tokenTTL time.Duration
+	mu       sync.RWMutex
+	tokens   map[string]time.Time
+	users    map[int64]time.Time
+}
+
+// init a denylist cache, Load fills it
+func NewDenylistCache(db *sql.DB, tokenTTL time.Duration) *DenylistCache {
+	return &DenylistCache{
+		db:       db,
+		tokenTTL: tokenTTL,
+		tokens:   make(map[string]time.Time),
+		users:    make(map[int64]time.Time),
+	}
+}
+
+// check if a JWT is denied by its id, or because its user was denied after issuedAt
+func (c *DenylistCache) denied(jti string, userID int64, issuedAt time.Time) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if _, found := c.tokens[jti]; found {
+		return true
+	}
+
+	deniedAt, found := c.users[userID]
+
+	return found && !issuedAt.After(deniedAt)
+}
+
+// deny a JWT id until it expires
+func (c *DenylistCache) add(jti string, expiry time.Time) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	c.tokens[jti] = expiry
+	c.mu.Unlock()
+}
+
+// deny the JWTs of an user issued until deniedAt
+func (c *DenylistCache) denyUser(userID int64, deniedAt time.Time) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	c.users[userID] = deniedAt
+	c.mu.Unlock()
+}
+
+// read the denylist from the db, replacing the cached one
+func (c *DenylistCache) Load() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tokens := make(map[string]time.Time)
+
+	rows, err := c.db.QueryContext(ctx, `SELECT jti, expiry FROM jwt_denylist WHERE expiry > $1`, time.Now())
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var jti string
+		var expiry time.Time
+
+		err = rows.Scan(&jti, &expiry)
+		if err != nil {
+			return err
+		}
+
+		tokens[jti] = expiry
+	}
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	users := make(map[int64]time.Time)
+
+	rows, err = c.db.QueryContext(ctx, `SELECT user_id, denied_at FROM jwt_user_denylist WHERE denied_at > $1`, time.Now().Add(-c.tokenTTL))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var userID int64
+		var deniedAt time.Time
+
+		err = rows.Scan(&userID, &deniedAt)
+		if err != nil {
+			return err
+		}
+
+		users[userID] = deniedAt
+	}
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.tokens, c.users = tokens, users
+	c.mu.Unlock()
+
+	return nil
+}
+
+// listen to the db denylist notifications, reloading the cache on each of them.
+// this keeps the cache of every API instance up to date, whatever instance (or manual SQL) denied the tokens.
+// the cache is also reloaded periodically, which drops the expired entries.
+func (c *DenylistCache) Listen(dsn string, onError func(error)) error {
+	listener := pq.NewListener(dsn, 10*time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			onError(err)
+		}
+	})
+
+	err := listener.Listen(jwtDenylistChangedChannel)
+	if err != nil {
+		listener.Close()
+		return err
+	}
+
+	go func() {
+		for {
+			select {
+			// a nil notification is sent after a reconnection, the notifications sent while the connection was down are lost
+			case <-listener.Notify:
+			case <-time.After(90 * time.Second):
+				go listener.Ping()
+			}
+
+			err := c.Load()
+			if err != nil {
+				onError(err)
+			}
+		}
+	}()
+
+	return nil
+}
@@ -0,0 +1,129 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// create an email change token for the user, the new email is stored with it until the change is confirmed.
+// a previous email change that was not confirmed is discarded.
+func (u UserDBConnection) RequestEmailChange(userID int64, newEmail string, ttl time.Duration) (*Token, error) {
+	token, err := generateToken(userID, ttl, ScopeEmailChange)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := u.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM tokens WHERE scope = $1 AND user_id = $2`, ScopeEmailChange, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+        INSERT INTO tokens (hash, user_id, expiry, scope) 
+        VALUES ($1, $2, $3, $4)`
+
+	_, err = tx.ExecContext(ctx, query, token.Hash, token.UserID, token.Expiry, token.Scope)
+	if err != nil {
+		return nil, err
+	}
+
+	query = `
+        INSERT INTO email_changes (token_hash, new_email) 
+        VALUES ($1, $2)`
+
+	_, err = tx.ExecContext(ctx, query, token.Hash, newEmail)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+// change the user email to the one stored with the email change token.
+// the email change and password reset tokens of the user are deleted, as they were sent to the old email.
+func (u UserDBConnection) ConfirmEmailChange(tokenPlaintext string) (*User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := u.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+        SELECT tokens.user_id, email_changes.new_email
+        FROM tokens
+        INNER JOIN email_changes ON email_changes.token_hash = tokens.hash
+        WHERE tokens.hash = $1
+        AND tokens.scope = $2
+        AND tokens.expiry > $3
+        FOR UPDATE`
+
+	var userID int64
+	var newEmail string
+
+	err = tx.QueryRowContext(ctx, query, HashToken(tokenPlaintext), ScopeEmailChange, time.Now()).Scan(&userID, &newEmail)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrTokenRecordNotFoundOrExpiry
+		default:
+			return nil, err
+		}
+	}
+
+	query = `
+        UPDATE users SET email = $1
+        WHERE id = $2 AND deleted_at IS NULL
+        RETURNING id, created_at, name, email, password_hash, activated`
+
+	var user User
+
+	err = tx.QueryRowContext(ctx, query, newEmail, userID).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+	)
+	if err != nil {
+		switch {
+		case isDuplicateEmail(err):
+			return nil, ErrDuplicateEmail
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrUserRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM tokens WHERE scope IN ($1, $2) AND user_id = $3`, ScopeEmailChange, ScopePasswordReset, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
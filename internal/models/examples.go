@@ -6,7 +6,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"net/http"
+	"strings"
 	"time"
 
 	"go.api.template/internal/validator"
@@ -17,13 +17,48 @@ var (
 )
 
 type Example struct {
-	Id            int64     `json:"id"`
-	ExampleValue1 float64   `json:"-"`
-	ExampleValue2 string    `json:"-"`
-	ExampleValue3 string    `json:"example_value_3"`
-	CreatedAt     time.Time `json:"created_at"`
+	Id            int64      `json:"id"`
+	ExampleValue1 float64    `json:"-"`
+	ExampleValue2 string     `json:"-"`
+	ExampleValue3 string     `json:"example_value_3"`
+	OwnerID       *int64     `json:"owner_id"`
+	CreatedAt     time.Time  `json:"created_at"`
+	DeletedAt     *time.Time `json:"deleted_at,omitempty"`
+	Version       int32      `json:"version"`
 }
 
+// partial update of an example, omitted fields are not changed
+type ExamplePatch struct {
+	ExampleValue1 Optional[float64] `json:"example_value_1"`
+	ExampleValue2 Optional[string]  `json:"example_value_2"`
+	ExampleValue3 Optional[string]  `json:"example_value_3"`
+	Version       *int32            `json:"version"`
+}
+
+// fields of the examples that can be filtered on the list
+var ExampleFilterSafelist = FilterSafelist{
+	"id":              {Column: "id", Type: FilterTypeInteger, Operators: []string{OpEq, OpNe, OpGt, OpGte, OpLt, OpLte, OpIn}},
+	"example_value_1": {Column: "example_value_1", Type: FilterTypeNumber, Operators: []string{OpEq, OpNe, OpGt, OpGte, OpLt, OpLte, OpIn}},
+	"example_value_2": {Column: "example_value_2", Type: FilterTypeText, Operators: []string{OpEq, OpNe, OpContains, OpIn}},
+	"example_value_3": {Column: "example_value_3", Type: FilterTypeText, Operators: []string{OpEq, OpNe, OpContains, OpIn}},
+	"owner_id":        {Column: "owner_id", Type: FilterTypeInteger, Operators: []string{OpEq, OpIn}},
+	"created_at":      {Column: "created_at", Type: FilterTypeTime, Operators: []string{OpGt, OpGte, OpLt, OpLte}},
+}
+
+// JSON fields of an example that can be selected with a sparse fieldset, example_concat_value is built from two columns
+var ExampleFieldSafelist = FieldSafelist{
+	"id":                   {"id"},
+	"example_value_3":      {"example_value_3"},
+	"example_concat_value": {"example_value_1", "example_value_2"},
+	"owner_id":             {"owner_id"},
+	"created_at":           {"created_at"},
+	"deleted_at":           {"deleted_at"},
+	"version":              {"version"},
+}
+
+// columns of the examples that the list can be sorted by
+var ExampleSortColumns = []string{"id", "created_at", "example_value_1", "example_value_2", "example_value_3"}
+
 type ExampleDBConnection struct {
 	DB *sql.DB
 }
@@ -31,9 +66,9 @@ type ExampleDBConnection struct {
 // Insert an example in DB
 func (e ExampleDBConnection) Insert(example *Example) error {
 	query := `
-			INSERT INTO examples (example_value_1, example_value_2, example_value_3)
-			VALUES($1, $2, $3)
-			RETURNING id, created_at`
+			INSERT INTO examples (example_value_1, example_value_2, example_value_3, owner_id)
+			VALUES($1, $2, $3, $4)
+			RETURNING id, created_at, version`
 
 	// This context statement limits the query to be executed in 3s
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
@@ -42,32 +77,41 @@ func (e ExampleDBConnection) Insert(example *Example) error {
 	return e.DB.QueryRowContext(ctx, query,
 		example.ExampleValue1,
 		example.ExampleValue2,
-		example.ExampleValue3).Scan(
+		example.ExampleValue3,
+		example.OwnerID).Scan(
 		&example.Id,
-		&example.CreatedAt)
+		&example.CreatedAt,
+		&example.Version)
 }
 
 // Get an example from DB
 func (e ExampleDBConnection) Get(id int64) (*Example, error) {
+	return e.GetFields(id, nil)
+}
+
+// Get an example from DB selecting only the columns of the fields (see ExampleFieldSafelist), no fields selects all of them
+func (e ExampleDBConnection) GetFields(id int64, fields []string) (*Example, error) {
 	if id < 1 {
 		return nil, ErrExampleRecordNotFound
 	}
 
-	query := `
-		SELECT example_value_1, example_value_2, example_value_3, created_at
-		FROM examples
-		WHERE id = $1`
+	// the version is always read, it is the ETag of the example
+	if len(fields) > 0 {
+		fields = append(fields[:len(fields):len(fields)], "version")
+	}
 
 	example := Example{Id: id}
+	columns := ExampleFieldSafelist.columns(fields)
+
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM examples
+		WHERE id = $1 AND deleted_at IS NULL`, strings.Join(columns, ", "))
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := e.DB.QueryRowContext(ctx, query, id).Scan(
-		&example.ExampleValue1,
-		&example.ExampleValue2,
-		&example.ExampleValue3,
-		&example.CreatedAt)
+	err := e.DB.QueryRowContext(ctx, query, id).Scan(example.scanColumns(columns)...)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, ErrExampleRecordNotFound
@@ -79,30 +123,50 @@ func (e ExampleDBConnection) Get(id int64) (*Example, error) {
 	return &example, nil
 }
 
-// Get all examples from DB
-func (e ExampleDBConnection) GetAll(exampleValue2 string, exampleValue3 string, filters *Filters) ([]*Example, *Metadata, error) {
+// Get all examples from DB matching the filters conditions, search is a full-text search over example_value_3 ("" for no search),
+// ownerID scopes the examples to an owner (0 for all owners), deleted selects the deleted examples instead of the active ones.
+// the "relevance" sort column orders by the search rank. only the columns of the fields are selected (see ExampleFieldSafelist).
+func (e ExampleDBConnection) GetAll(search string, ownerID int64, deleted bool, fields []string, filters *Filters) ([]*Example, *Metadata, error) {
 	totalRecords := 0
 	result := []*Example{}
+	keys := [][]any{}
+	columns := ExampleFieldSafelist.columns(fields)
+
+	// relevance is not a column, it is the rank of the search
+	expressions := map[string]string{"relevance": "ts_rank(search, plainto_tsquery('simple', $5))"}
+
+	args := []any{
+		ownerID,
+		deleted,
+		filters.limit(),
+		filters.offset(),
+		search,
+	}
+
+	conditions := filters.where(&args)
+	orderBy, keyset, sortKeys := filters.keyset(expressions, &args)
 
 	// it’s not possible to use placeholder parameters for column names or SQL keywords (including ASC and DESC)
 	// thats why use fmt.Sprintf for "ORDER BY"
 	query := fmt.Sprintf(`
-		SELECT count(*) OVER(), id, example_value_1, example_value_2, example_value_3, created_at
+		SELECT %s, %s, %s
 		FROM examples
-		WHERE (LOWER(example_value_2) = LOWER($1) OR $1 = '') 
-		AND (LOWER(example_value_3) = LOWER($2) OR $2 = '') 
-		ORDER BY %s %s, id ASC
-		LIMIT $3 OFFSET $4`, filters.SortColumn, filters.SortDirection)
+		WHERE (owner_id = $1 OR $1 = 0)
+		AND (deleted_at IS NOT NULL) = $2
+		AND (search @@ plainto_tsquery('simple', $5) OR $5 = '')
+		AND %s
+		AND %s
+		ORDER BY %s
+		LIMIT $3 OFFSET $4`, filters.countColumn(), strings.Join(columns, ", "), sortKeys, conditions, keyset, orderBy)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	rows, err := e.DB.QueryContext(ctx, query,
-		exampleValue2,
-		exampleValue3,
-		filters.limit(),
-		filters.offset())
+	rows, err := e.DB.QueryContext(ctx, query, args...)
 	if err != nil {
+		if filters.keysetPagination() && isInvalidCursorValue(err) {
+			return nil, &Metadata{}, ErrInvalidCursor
+		}
 		return nil, &Metadata{}, err
 	}
 
@@ -110,57 +174,50 @@ func (e ExampleDBConnection) GetAll(exampleValue2 string, exampleValue3 string,
 
 	for rows.Next() {
 		var row Example
+		key := make([]any, filters.sortKeysCount())
+
+		dest := append([]any{&totalRecords}, row.scanColumns(columns)...)
 
-		err := rows.Scan(
-			&totalRecords,
-			&row.Id,
-			&row.ExampleValue1,
-			&row.ExampleValue2,
-			&row.ExampleValue3,
-			&row.CreatedAt)
+		err := rows.Scan(append(dest, scanKeys(key)...)...)
 		if err != nil {
 			return nil, &Metadata{}, err
 		}
 
 		result = append(result, &row)
+		keys = append(keys, key)
 	}
 
 	if err = rows.Err(); err != nil {
 		return nil, &Metadata{}, err
 	}
 
-	metadata := InitMetadata(totalRecords, filters.Page, filters.PageSize)
+	result, metadata := paginate(filters, result, keys, totalRecords)
 
 	return result, metadata, nil
 }
 
-// Update an example from DB
-func (e ExampleDBConnection) Update(example *Example) error {
-	query := "UPDATE examples Set"
-	parameterCount := 1
-	args := []any{}
-
-	if validator.NotCero(example.ExampleValue1) {
-		query += fmt.Sprintf(" example_value_1 = $%d,", parameterCount)
-		parameterCount++
-		args = append(args, example.ExampleValue1)
-	}
-	if validator.NotBlank(example.ExampleValue2) {
-		query += fmt.Sprintf(" example_value_2 = $%d,", parameterCount)
-		parameterCount++
-		args = append(args, example.ExampleValue2)
-	}
-	if validator.NotBlank(example.ExampleValue3) {
-		query += fmt.Sprintf(" example_value_3 = $%d,", parameterCount)
-		parameterCount++
-		args = append(args, example.ExampleValue3)
+// Update all the fields of an example in DB, ownerID scopes the update to an owner (0 for any owner).
+// the update only applies if the example is still on example.Version, otherwise ErrEditConflict is returned.
+// if the example no longer exists (deleted meanwhile) ErrExampleRecordNotFound is returned.
+func (e ExampleDBConnection) Update(example *Example, ownerID int64) error {
+	query := `
+		UPDATE examples
+		SET example_value_1 = $1, example_value_2 = $2, example_value_3 = $3, version = version + 1
+		WHERE id = $4
+		AND (owner_id = $5 OR $5 = 0)
+		AND deleted_at IS NULL
+		AND version = $6
+		RETURNING example_value_1, example_value_2, example_value_3, owner_id, created_at, version`
+
+	args := []any{
+		example.ExampleValue1,
+		example.ExampleValue2,
+		example.ExampleValue3,
+		example.Id,
+		ownerID,
+		example.Version,
 	}
 
-	query = query[:len(query)-1]
-	query += fmt.Sprintf(" WHERE id = $%d", parameterCount)
-	query += " RETURNING example_value_1, example_value_2, example_value_3, created_at"
-	args = append(args, example.Id)
-
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
@@ -168,10 +225,12 @@ func (e ExampleDBConnection) Update(example *Example) error {
 		&example.ExampleValue1,
 		&example.ExampleValue2,
 		&example.ExampleValue3,
-		&example.CreatedAt)
+		&example.OwnerID,
+		&example.CreatedAt,
+		&example.Version)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return ErrExampleRecordNotFound
+			return e.updateMissError(example.Id, ownerID)
 		} else {
 			return err
 		}
@@ -180,20 +239,50 @@ func (e ExampleDBConnection) Update(example *Example) error {
 	return nil
 }
 
-// Delete an example from DB
-func (e ExampleDBConnection) Delete(id int64) error {
+// tell why an update did not match any row, the example does not exist (or was deleted, or belongs to another owner)
+// or it is on another version
+func (e ExampleDBConnection) updateMissError(id int64, ownerID int64) error {
+	query := `
+		SELECT EXISTS (
+			SELECT 1 FROM examples
+			WHERE id = $1
+			AND (owner_id = $2 OR $2 = 0)
+			AND deleted_at IS NULL
+		)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var exists bool
+
+	err := e.DB.QueryRowContext(ctx, query, id, ownerID).Scan(&exists)
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		return ErrExampleRecordNotFound
+	}
+
+	return ErrEditConflict
+}
+
+// Mark an example as deleted, it can be restored until it is purged. ownerID scopes the delete to an owner (0 for any owner)
+func (e ExampleDBConnection) Delete(id int64, ownerID int64) error {
 	if id < 1 {
 		return ErrExampleRecordNotFound
 	}
 
 	query := `
-		DELETE FROM examples
-		WHERE id = $1`
+		UPDATE examples SET deleted_at = NOW()
+		WHERE id = $1
+		AND (owner_id = $2 OR $2 = 0)
+		AND deleted_at IS NULL`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	result, err := e.DB.ExecContext(ctx, query, id)
+	result, err := e.DB.ExecContext(ctx, query, id, ownerID)
 	if err != nil {
 		return err
 	}
@@ -210,6 +299,91 @@ func (e ExampleDBConnection) Delete(id int64) error {
 	return nil
 }
 
+// Restore a deleted example
+func (e ExampleDBConnection) Restore(id int64) (*Example, error) {
+	if id < 1 {
+		return nil, ErrExampleRecordNotFound
+	}
+
+	query := `
+		UPDATE examples SET deleted_at = NULL
+		WHERE id = $1 AND deleted_at IS NOT NULL
+		RETURNING example_value_1, example_value_2, example_value_3, owner_id, created_at, version`
+
+	example := Example{Id: id}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := e.DB.QueryRowContext(ctx, query, id).Scan(
+		&example.ExampleValue1,
+		&example.ExampleValue2,
+		&example.ExampleValue3,
+		&example.OwnerID,
+		&example.CreatedAt,
+		&example.Version)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrExampleRecordNotFound
+		} else {
+			return nil, err
+		}
+	}
+
+	return &example, nil
+}
+
+// Permanently delete the examples deleted before a time
+func (e ExampleDBConnection) Purge(before time.Time) (int64, error) {
+	query := `
+		DELETE FROM examples
+		WHERE deleted_at < $1`
+
+	// a purge can remove many rows, it gets a longer timeout than the requests queries
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	result, err := e.DB.ExecContext(ctx, query, before)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// return the scan destinations of the example columns
+func (e *Example) scanColumns(columns []string) []any {
+	dest := make([]any, len(columns))
+
+	for i, column := range columns {
+		switch column {
+		case "id":
+			dest[i] = &e.Id
+		case "example_value_1":
+			dest[i] = &e.ExampleValue1
+		case "example_value_2":
+			dest[i] = &e.ExampleValue2
+		case "example_value_3":
+			dest[i] = &e.ExampleValue3
+		case "owner_id":
+			dest[i] = &e.OwnerID
+		case "created_at":
+			dest[i] = &e.CreatedAt
+		case "deleted_at":
+			dest[i] = &e.DeletedAt
+		case "version":
+			dest[i] = &e.Version
+		}
+	}
+
+	return dest
+}
+
+// check if the example belongs to the user
+func (e *Example) OwnedBy(userID int64) bool {
+	return e.OwnerID != nil && *e.OwnerID == userID
+}
+
 // Automatically used when trying to encode this type to json.
 func (e Example) MarshalJSON() ([]byte, error) {
 
@@ -229,14 +403,51 @@ func (e Example) MarshalJSON() ([]byte, error) {
 	return json.Marshal(aux)
 }
 
-// validate input example fields
-func (e *Example) ValidateExample(r *http.Request) *validator.Validator {
+// Automatically used when trying to decode this type from json, the type errors keep the field name
+func (p *ExamplePatch) UnmarshalJSON(data []byte) error {
+	return unmarshalFields(data, map[string]any{
+		"example_value_1": &p.ExampleValue1,
+		"example_value_2": &p.ExampleValue2,
+		"example_value_3": &p.ExampleValue3,
+		"version":         &p.Version,
+	})
+}
+
+// check if the patch does not change any field
+func (p *ExamplePatch) Empty() bool {
+	return !p.ExampleValue1.Set && !p.ExampleValue2.Set && !p.ExampleValue3.Set
+}
+
+// validate the patch fields, no field can be removed. the patched example is validated with ValidateExample
+func (p *ExamplePatch) ValidateExamplePatch() *validator.Validator {
 	v := validator.Validator{}
 
-	if r.Method == http.MethodPost {
-		v.Check(validator.NotBlank(e.ExampleValue2), "example_value_2", "must be provided")
-		v.Check(validator.NotBlank(e.ExampleValue3), "example_value_3", "must be provided")
+	v.Check(p.ExampleValue1.NotNull(), "example_value_1", "must not be null")
+	v.Check(p.ExampleValue2.NotNull(), "example_value_2", "must not be null")
+	v.Check(p.ExampleValue3.NotNull(), "example_value_3", "must not be null")
+
+	return &v
+}
+
+// apply the present fields to an example
+func (p *ExamplePatch) ApplyTo(e *Example) {
+	if p.ExampleValue1.Set {
+		e.ExampleValue1 = p.ExampleValue1.Value
 	}
+	if p.ExampleValue2.Set {
+		e.ExampleValue2 = p.ExampleValue2.Value
+	}
+	if p.ExampleValue3.Set {
+		e.ExampleValue3 = p.ExampleValue3.Value
+	}
+}
+
+// validate example fields, a partial update is validated once applied to the stored example
+func (e *Example) ValidateExample() *validator.Validator {
+	v := validator.Validator{}
+
+	v.Check(validator.NotBlank(e.ExampleValue2), "example_value_2", "must be provided")
+	v.Check(validator.NotBlank(e.ExampleValue3), "example_value_3", "must be provided")
 
 	v.Check(validator.MinNumber(e.ExampleValue1, 0), "example_value_1", "must be a positive number")
 	v.Check(validator.MaxChars(e.ExampleValue2, 4), "example_value_2", "must not be more than 4 bytes long")
@@ -0,0 +1,43 @@
+package models
+
+import (
+	"fmt"
+	"sort"
+
+	"go.api.template/internal/validator"
+)
+
+// JSON fields of a resource that a client can select (sparse fieldsets), and the columns each one is built from
+type FieldSafelist map[string][]string
+
+// validate the fields selected by a client, no fields selects all of them
+func (s FieldSafelist) ValidateFields(fields []string, v *validator.Validator) {
+	for _, field := range fields {
+		_, found := s[field]
+		v.Check(found, "fields", fmt.Sprintf("invalid field %q", field))
+	}
+}
+
+// return the columns to select for the fields, all the columns when no field is selected.
+// the columns are sorted, so a fieldset always builds the same query
+func (s FieldSafelist) columns(fields []string) []string {
+	if len(fields) == 0 {
+		for field := range s {
+			fields = append(fields, field)
+		}
+	}
+
+	columns := []string{}
+
+	for _, field := range fields {
+		for _, column := range s[field] {
+			if !validator.PermittedValue(column, columns...) {
+				columns = append(columns, column)
+			}
+		}
+	}
+
+	sort.Strings(columns)
+
+	return columns
+}
@@ -0,0 +1,156 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+type LoginFailuresDBConnection struct {
+	DB *sql.DB
+}
+
+// key used to track the failed logins of an account
+func LoginFailureKeyForEmail(email string) string {
+	return "email:" + strings.ToLower(email)
+}
+
+// key used to track the failed logins of a client ip
+func LoginFailureKeyForIP(ip string) string {
+	return "ip:" + ip
+}
+
+// get the latest lockout end between the keys, zero time if none of them is locked
+func (l LoginFailuresDBConnection) LockedUntil(keys ...string) (time.Time, error) {
+	query := `
+        SELECT MAX(locked_until)
+        FROM login_failures
+        WHERE key = ANY($1) AND locked_until > $2`
+
+	var lockedUntil sql.NullTime
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := l.DB.QueryRowContext(ctx, query, pq.Array(keys), time.Now()).Scan(&lockedUntil)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return lockedUntil.Time, nil
+}
+
+// count a failed login for the key, the counter starts again when the last failure is older than window.
+// once the threshold is reached the key is locked, doubling the lockout on every new failure up to max.
+// returns the number of consecutive failures and the lockout end (zero time if not locked).
+func (l LoginFailuresDBConnection) RecordFailure(key string, window time.Duration, threshold int, base time.Duration, max time.Duration) (int, time.Time, error) {
+	query := `
+        INSERT INTO login_failures (key, failures, last_failure)
+        VALUES ($1, 1, NOW())
+        ON CONFLICT (key) DO UPDATE
+        SET failures = CASE 
+                WHEN login_failures.last_failure < NOW() - $2 * INTERVAL '1 second' THEN 1
+                ELSE login_failures.failures + 1
+            END,
+            last_failure = NOW()
+        RETURNING failures`
+
+	var failures int
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := l.DB.QueryRowContext(ctx, query, key, int64(window.Seconds())).Scan(&failures)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+
+	if failures < threshold {
+		return failures, time.Time{}, nil
+	}
+
+	lockout := base
+	for i := threshold; i < failures && lockout < max; i++ {
+		lockout *= 2
+	}
+	if lockout > max {
+		lockout = max
+	}
+
+	lockedUntil := time.Now().Add(lockout)
+
+	query = `
+        UPDATE login_failures
+        SET locked_until = $2
+        WHERE key = $1`
+
+	_, err = l.DB.ExecContext(ctx, query, key, lockedUntil)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+
+	return failures, lockedUntil, nil
+}
+
+// forget the failed logins of the key, unlocking it. returns the number of failures forgotten
+func (l LoginFailuresDBConnection) Reset(key string) (int, error) {
+	query := `
+        DELETE FROM login_failures
+        WHERE key = $1
+        RETURNING failures`
+
+	var failures int
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := l.DB.QueryRowContext(ctx, query, key).Scan(&failures)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return 0, err
+	}
+
+	return failures, nil
+}
+
+// forget some of the failed logins of the key, a lockout already in place is kept
+func (l LoginFailuresDBConnection) Forgive(key string, failures int) error {
+	if failures < 1 {
+		return nil
+	}
+
+	query := `
+        UPDATE login_failures
+        SET failures = GREATEST(failures - $2, 0)
+        WHERE key = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := l.DB.ExecContext(ctx, query, key, failures)
+
+	return err
+}
+
+// permanently delete the keys whose failures are older than window and that are not locked, they would start counting again anyway.
+// returns the number of keys deleted
+func (l LoginFailuresDBConnection) Purge(window time.Duration) (int64, error) {
+	query := `
+        DELETE FROM login_failures
+        WHERE last_failure < NOW() - $1 * INTERVAL '1 second'
+        AND (locked_until IS NULL OR locked_until <= NOW())`
+
+	// a purge can remove many rows, it gets a longer timeout than the requests queries
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	result, err := l.DB.ExecContext(ctx, query, int64(window.Seconds()))
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
@@ -0,0 +1,75 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// an input field of a partial update (PATCH), it tells apart an omitted field, an explicit null and a value, including the zero value
+type Optional[T any] struct {
+	Set   bool
+	Null  bool
+	Value T
+}
+
+// only called when the field is present in the json, so an omitted field keeps Set = false
+func (o *Optional[T]) UnmarshalJSON(data []byte) error {
+	o.Set = true
+
+	if string(data) == "null" {
+		o.Null = true
+		return nil
+	}
+
+	return json.Unmarshal(data, &o.Value)
+}
+
+// check that a present field is not null, for the fields that cannot be removed
+func (o Optional[T]) NotNull() bool {
+	return !o.Set || !o.Null
+}
+
+// decode a json object into the destinations of its fields, unknown fields are an error.
+// the type errors returned by a custom UnmarshalJSON (like the one of Optional) lose the field name,
+// decoding each field on its own keeps it, so the client knows which field is wrong.
+func unmarshalFields(data []byte, fields map[string]any) error {
+	var object map[string]json.RawMessage
+
+	err := json.Unmarshal(data, &object)
+	if err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+
+	// the first wrong field in a stable order
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		dst, found := fields[key]
+		if !found {
+			return fmt.Errorf("json: unknown field %q", key)
+		}
+
+		err = json.Unmarshal(object[key], dst)
+		if err != nil {
+			var unmarshalTypeError *json.UnmarshalTypeError
+			if errors.As(err, &unmarshalTypeError) {
+				if unmarshalTypeError.Field != "" {
+					unmarshalTypeError.Field = key + "." + unmarshalTypeError.Field
+				} else {
+					unmarshalTypeError.Field = key
+				}
+			}
+
+			return err
+		}
+	}
+
+	return nil
+}
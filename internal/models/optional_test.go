@@ -0,0 +1,86 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestExamplePatchUnmarshalJSON(t *testing.T) {
+	version := int32(3)
+
+	tests := []struct {
+		name string
+		json string
+		want ExamplePatch
+	}{
+		{"omitted", `{}`, ExamplePatch{}},
+		{"null", `{"example_value_2": null}`, ExamplePatch{ExampleValue2: Optional[string]{Set: true, Null: true}}},
+		{"zero value", `{"example_value_1": 0, "example_value_2": ""}`, ExamplePatch{
+			ExampleValue1: Optional[float64]{Set: true, Value: 0},
+			ExampleValue2: Optional[string]{Set: true, Value: ""},
+		}},
+		{"value", `{"example_value_1": 1.5, "example_value_3": "a", "version": 3}`, ExamplePatch{
+			ExampleValue1: Optional[float64]{Set: true, Value: 1.5},
+			ExampleValue3: Optional[string]{Set: true, Value: "a"},
+			Version:       &version,
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var patch ExamplePatch
+
+			err := json.Unmarshal([]byte(tt.json), &patch)
+			if err != nil {
+				t.Fatalf("Unmarshal() error = %v", err)
+			}
+
+			if !reflect.DeepEqual(patch, tt.want) {
+				t.Errorf("Unmarshal() = %+v, want %+v", patch, tt.want)
+			}
+		})
+	}
+}
+
+// a wrong type reports the json name of the field
+func TestExamplePatchUnmarshalJSONTypeError(t *testing.T) {
+	tests := []struct {
+		json  string
+		field string
+	}{
+		{`{"example_value_1": "1.5"}`, "example_value_1"},
+		{`{"example_value_2": 2}`, "example_value_2"},
+		{`{"example_value_3": true}`, "example_value_3"},
+		{`{"version": "3"}`, "version"},
+		// the first wrong field in the order of the sorted keys
+		{`{"example_value_3": 1, "example_value_1": "a"}`, "example_value_1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.json, func(t *testing.T) {
+			var patch ExamplePatch
+
+			err := json.Unmarshal([]byte(tt.json), &patch)
+
+			var unmarshalTypeError *json.UnmarshalTypeError
+			if !errors.As(err, &unmarshalTypeError) {
+				t.Fatalf("Unmarshal() error = %v, want *json.UnmarshalTypeError", err)
+			}
+
+			if unmarshalTypeError.Field != tt.field {
+				t.Errorf("UnmarshalTypeError.Field = %q, want %q", unmarshalTypeError.Field, tt.field)
+			}
+		})
+	}
+}
+
+func TestExamplePatchUnmarshalJSONUnknownField(t *testing.T) {
+	var patch ExamplePatch
+
+	err := json.Unmarshal([]byte(`{"example_value_1": 1, "owner_id": 2}`), &patch)
+	if err == nil || err.Error() != `json: unknown field "owner_id"` {
+		t.Errorf("Unmarshal() error = %v, want unknown field %q", err, "owner_id")
+	}
+}
@@ -14,7 +14,8 @@ var ErrNotPermitted = errors.New("your user account doesn't have the necessary p
 type Permissions []string
 
 type PermissionsDBConnection struct {
-	DB *sql.DB
+	DB    *sql.DB
+	Cache *PermissionsCache
 }
 
 func (p Permissions) Include(element string) bool {
@@ -27,14 +28,29 @@ func (p Permissions) Include(element string) bool {
 	return false
 }
 
-// get all permission from an specific user
+// get all permission from an specific user, the ones granted directly and the ones granted by its roles
 func (p PermissionsDBConnection) GetAllForUser(userID int64) (Permissions, error) {
+	var generation uint64
+
+	if p.Cache != nil {
+		if permissions, found := p.Cache.get(userID); found {
+			return permissions, nil
+		}
+
+		generation = p.Cache.currentGeneration()
+	}
+
 	query := `
         SELECT permissions.code
         FROM permissions
         INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
-        INNER JOIN users ON users_permissions.user_id = users.id
-        WHERE users.id = $1`
+        WHERE users_permissions.user_id = $1
+        UNION
+        SELECT permissions.code
+        FROM permissions
+        INNER JOIN roles_permissions ON roles_permissions.permission_id = permissions.id
+        INNER JOIN users_roles ON users_roles.role_id = roles_permissions.role_id
+        WHERE users_roles.user_id = $1`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -63,19 +79,127 @@ func (p PermissionsDBConnection) GetAllForUser(userID int64) (Permissions, error
 		return nil, err
 	}
 
+	if p.Cache != nil {
+		p.Cache.set(userID, permissions, generation)
+	}
+
 	return permissions, nil
 }
 
-// add permissions to an user
+// get all the permission codes that exist
+func (p PermissionsDBConnection) GetAll() (Permissions, error) {
+	query := `
+        SELECT code
+        FROM permissions
+        ORDER BY code`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := p.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	permissions := Permissions{}
+
+	for rows.Next() {
+		var permission string
+
+		err := rows.Scan(&permission)
+		if err != nil {
+			return nil, err
+		}
+
+		permissions = append(permissions, permission)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return permissions, nil
+}
+
+// get the permissions granted directly to an specific user, without the ones granted by its roles
+func (p PermissionsDBConnection) GetDirectForUser(userID int64) (Permissions, error) {
+	query := `
+        SELECT permissions.code
+        FROM permissions
+        INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
+        WHERE users_permissions.user_id = $1
+        ORDER BY permissions.code`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := p.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	permissions := Permissions{}
+
+	for rows.Next() {
+		var permission string
+
+		err := rows.Scan(&permission)
+		if err != nil {
+			return nil, err
+		}
+
+		permissions = append(permissions, permission)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return permissions, nil
+}
+
+// add permissions to an user, the ones already granted are ignored
 func (p PermissionsDBConnection) AddForUser(userID int64, codes ...string) error {
 	query := `
         INSERT INTO users_permissions
-        SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)`
+        SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)
+        ON CONFLICT DO NOTHING`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := p.DB.ExecContext(ctx, query, userID, pq.Array(codes))
+	if err != nil {
+		return err
+	}
+
+	p.Cache.Invalidate(userID)
+
+	return nil
+}
+
+// remove permissions granted directly to an user
+func (p PermissionsDBConnection) RemoveForUser(userID int64, codes ...string) error {
+	query := `
+        DELETE FROM users_permissions
+        USING permissions
+        WHERE users_permissions.permission_id = permissions.id
+        AND users_permissions.user_id = $1
+        AND permissions.code = ANY($2)`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
 	_, err := p.DB.ExecContext(ctx, query, userID, pq.Array(codes))
+	if err != nil {
+		return err
+	}
+
+	p.Cache.Invalidate(userID)
 
-	return err
+	return nil
 }
@@ -0,0 +1,181 @@
+package models
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// channel notified by the db triggers when the permissions of an user ("<user id>") or of everyone ("*") change
+const permissionsChangedChannel = "permissions_changed"
+
+type permissionsCacheEntry struct {
+	permissions Permissions
+	expiry      time.Time
+}
+
+// in-process cache of the permissions of each user, entries live at most ttl
+type PermissionsCache struct {
+	ttl     time.Duration
+	mu      sync.RWMutex
+	entries map[int64]permissionsCacheEntry
+	// bumped by every invalidation, so permissions read from the db before an invalidation are not cached
+	generation uint64
+	hits       atomic.Int64
+	misses     atomic.Int64
+}
+
+// init a permissions cache, expired entries are removed in background
+func NewPermissionsCache(ttl time.Duration) *PermissionsCache {
+	c := &PermissionsCache{
+		ttl:     ttl,
+		entries: make(map[int64]permissionsCacheEntry),
+	}
+
+	go func() {
+		for {
+			time.Sleep(ttl)
+
+			c.mu.Lock()
+
+			for userID, entry := range c.entries {
+				if time.Now().After(entry.expiry) {
+					delete(c.entries, userID)
+				}
+			}
+
+			c.mu.Unlock()
+		}
+	}()
+
+	return c
+}
+
+// get the cached permissions of an user
+func (c *PermissionsCache) get(userID int64) (Permissions, bool) {
+	c.mu.RLock()
+	entry, found := c.entries[userID]
+	c.mu.RUnlock()
+
+	if !found || time.Now().After(entry.expiry) {
+		c.misses.Add(1)
+		return nil, false
+	}
+
+	c.hits.Add(1)
+	return entry.permissions, true
+}
+
+// current generation of the cache, to capture before reading the permissions from the db
+func (c *PermissionsCache) currentGeneration() uint64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.generation
+}
+
+// cache the permissions of an user read from the db at the given generation,
+// they are discarded if the cache was invalidated meanwhile, as they may be stale
+func (c *PermissionsCache) set(userID int64, permissions Permissions, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.generation != generation {
+		return
+	}
+
+	c.entries[userID] = permissionsCacheEntry{permissions: permissions, expiry: time.Now().Add(c.ttl)}
+}
+
+// remove the cached permissions of an user
+func (c *PermissionsCache) Invalidate(userID int64) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	delete(c.entries, userID)
+	c.generation++
+	c.mu.Unlock()
+}
+
+// remove the cached permissions of every user
+func (c *PermissionsCache) InvalidateAll() {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	c.entries = make(map[int64]permissionsCacheEntry)
+	c.generation++
+	c.mu.Unlock()
+}
+
+// number of lookups served from the cache
+func (c *PermissionsCache) Hits() int64 {
+	if c == nil {
+		return 0
+	}
+
+	return c.hits.Load()
+}
+
+// number of lookups that had to query the db
+func (c *PermissionsCache) Misses() int64 {
+	if c == nil {
+		return 0
+	}
+
+	return c.misses.Load()
+}
+
+// listen to the db permissions notifications, invalidating the matching entries.
+// this keeps the cache of every API instance up to date, whatever instance (or manual SQL) changed the permissions.
+func (c *PermissionsCache) Listen(dsn string, onError func(error)) error {
+	listener := pq.NewListener(dsn, 10*time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			onError(err)
+		}
+
+		// notifications sent while the connection was down are lost
+		if event == pq.ListenerEventReconnected {
+			c.InvalidateAll()
+		}
+	})
+
+	err := listener.Listen(permissionsChangedChannel)
+	if err != nil {
+		listener.Close()
+		return err
+	}
+
+	go func() {
+		for {
+			select {
+			case notification := <-listener.Notify:
+				// a nil notification is sent after a reconnection
+				if notification == nil || notification.Extra == "*" {
+					c.InvalidateAll()
+					continue
+				}
+
+				userID, err := strconv.ParseInt(notification.Extra, 10, 64)
+				if err != nil {
+					c.InvalidateAll()
+					continue
+				}
+
+				c.Invalidate(userID)
+
+			// check the connection is still alive when no notifications are received
+			case <-time.After(90 * time.Second):
+				go listener.Ping()
+			}
+		}
+	}()
+
+	return nil
+}
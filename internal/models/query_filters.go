@@ -0,0 +1,201 @@
+package models
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+
+	"go.api.template/internal/validator"
+)
+
+// operators of the filter expressions, sent on the query string as "field[operator]=value" ("field=value" is eq)
+const (
+	OpEq       = "eq"
+	OpNe       = "ne"
+	OpGt       = "gt"
+	OpGte      = "gte"
+	OpLt       = "lt"
+	OpLte      = "lte"
+	OpContains = "contains"
+	OpIn       = "in"
+)
+
+// SQL types of the filterable columns, the values are validated in Go and cast in SQL
+const (
+	FilterTypeText    = "text"
+	FilterTypeNumber  = "numeric"
+	FilterTypeInteger = "bigint"
+	FilterTypeTime    = "timestamptz"
+	FilterTypeBool    = "boolean"
+)
+
+// maximum number of values of an "in" filter
+const maxFilterInValues = 100
+
+var filterKeyRX = regexp.MustCompile(`^([a-z0-9_]+)\[([a-z]+)\]$`)
+
+// a field that can be filtered, its column and type, and the operators allowed on it
+type FilterField struct {
+	Column    string
+	Type      string
+	Operators []string
+}
+
+// the fields of a resource that can be filtered, by query string name
+type FilterSafelist map[string]FilterField
+
+// a parsed filter expression
+type FilterCondition struct {
+	Field    FilterField
+	Operator string
+	Values   []string
+}
+
+// parse the filter expressions of the query string. keys with an operator must be safelisted fields,
+// keys without an operator are only filters if they are safelisted fields (other keys, like page or sort, are ignored).
+func (f *Filters) ParseConditions(qs url.Values, safelist FilterSafelist, v *validator.Validator) {
+	keys := make([]string, 0, len(qs))
+	for key := range qs {
+		keys = append(keys, key)
+	}
+
+	// a stable order builds the same SQL for the same query string
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		name, operator := key, OpEq
+
+		if matches := filterKeyRX.FindStringSubmatch(key); matches != nil {
+			name, operator = matches[1], matches[2]
+		} else if strings.Contains(key, "[") {
+			v.AddError(key, "invalid filter, use field[operator]=value")
+			continue
+		}
+
+		field, found := safelist[name]
+		if !found {
+			if strings.Contains(key, "[") {
+				v.AddError(key, "field cannot be filtered")
+			}
+			continue
+		}
+
+		if !validator.PermittedValue(operator, field.Operators...) {
+			v.AddError(key, fmt.Sprintf("invalid operator, use one of: %s", strings.Join(field.Operators, ", ")))
+			continue
+		}
+
+		for _, value := range qs[key] {
+			values := []string{value}
+
+			if operator == OpIn {
+				values = strings.Split(value, ",")
+				v.Check(len(values) <= maxFilterInValues, key, fmt.Sprintf("must not contain more than %d values", maxFilterInValues))
+			}
+
+			for i, value := range values {
+				values[i] = normalizeFilterValue(field.Type, value)
+				validateFilterValue(v, key, field.Type, values[i])
+			}
+
+			f.Conditions = append(f.Conditions, FilterCondition{Field: field, Operator: operator, Values: values})
+		}
+	}
+}
+
+// undo the decoding of a "+" as a space in the query string, so RFC 3339 offsets like +02:00 can be sent unescaped.
+// a RFC 3339 time never contains spaces
+func normalizeFilterValue(fieldType string, value string) string {
+	if fieldType == FilterTypeTime {
+		return strings.ReplaceAll(value, " ", "+")
+	}
+
+	return value
+}
+
+// check that a value can be cast to the field type
+func validateFilterValue(v *validator.Validator, key string, fieldType string, value string) {
+	var err error
+	var message string
+
+	switch fieldType {
+	case FilterTypeNumber:
+		_, err = strconv.ParseFloat(value, 64)
+		message = "must be a number"
+	case FilterTypeInteger:
+		_, err = strconv.ParseInt(value, 10, 64)
+		message = "must be an integer value"
+	case FilterTypeTime:
+		_, err = time.Parse(time.RFC3339, value)
+		message = "must be a RFC 3339 time"
+	case FilterTypeBool:
+		_, err = strconv.ParseBool(value)
+		message = "must be a boolean value"
+	}
+
+	if err != nil {
+		v.AddError(key, message)
+	}
+}
+
+// compile the conditions to a parameterized SQL condition ("TRUE" without conditions), the values are added to args.
+// column names and types come only from the safelist, never from the query string.
+func (f *Filters) where(args *[]any) string {
+	if len(f.Conditions) == 0 {
+		return "TRUE"
+	}
+
+	conditions := make([]string, len(f.Conditions))
+
+	for i, condition := range f.Conditions {
+		conditions[i] = condition.sql(args)
+	}
+
+	return strings.Join(conditions, " AND ")
+}
+
+// compile a condition, text comparisons are case-insensitive
+func (c FilterCondition) sql(args *[]any) string {
+	column := c.Field.Column
+	value := c.Values[0]
+
+	if c.Field.Type == FilterTypeText {
+		column = fmt.Sprintf("LOWER(%s)", column)
+		value = strings.ToLower(value)
+	}
+
+	switch c.Operator {
+	case OpContains:
+		*args = append(*args, escapeLike(value))
+		return fmt.Sprintf("%s LIKE '%%' || $%d || '%%'", column, len(*args))
+
+	case OpIn:
+		values := c.Values
+		if c.Field.Type == FilterTypeText {
+			values = make([]string, len(c.Values))
+			for i, value := range c.Values {
+				values[i] = strings.ToLower(value)
+			}
+		}
+
+		*args = append(*args, pq.Array(values))
+		return fmt.Sprintf("%s = ANY($%d::%s[])", column, len(*args), c.Field.Type)
+
+	default:
+		operators := map[string]string{OpEq: "=", OpNe: "<>", OpGt: ">", OpGte: ">=", OpLt: "<", OpLte: "<="}
+
+		*args = append(*args, value)
+		return fmt.Sprintf("%s %s $%d::%s", column, operators[c.Operator], len(*args), c.Field.Type)
+	}
+}
+
+// escape the LIKE wildcards of a value, so it is matched literally
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
@@ -0,0 +1,195 @@
+package models
+
+import (
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/lib/pq"
+
+	"go.api.template/internal/validator"
+)
+
+var testFilterSafelist = FilterSafelist{
+	"id":         {Column: "id", Type: FilterTypeInteger, Operators: []string{OpEq, OpNe, OpGt, OpGte, OpLt, OpLte, OpIn}},
+	"value":      {Column: "example_value_1", Type: FilterTypeNumber, Operators: []string{OpEq, OpGt}},
+	"name":       {Column: "name", Type: FilterTypeText, Operators: []string{OpEq, OpNe, OpContains, OpIn}},
+	"activated":  {Column: "activated", Type: FilterTypeBool, Operators: []string{OpEq}},
+	"created_at": {Column: "created_at", Type: FilterTypeTime, Operators: []string{OpGt, OpLt}},
+}
+
+// parse a query string with the test safelist
+func parseTestConditions(t *testing.T, query string) (*Filters, *validator.Validator) {
+	t.Helper()
+
+	qs, err := url.ParseQuery(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := &Filters{}
+	v := &validator.Validator{}
+	f.ParseConditions(qs, testFilterSafelist, v)
+
+	return f, v
+}
+
+func TestFiltersWhere(t *testing.T) {
+	tests := []struct {
+		query string
+		sql   string
+		args  []any
+	}{
+		{"", "TRUE", []any{}},
+		{"id=1", "id = $1::bigint", []any{"1"}},
+		{"id[eq]=1", "id = $1::bigint", []any{"1"}},
+		{"id[ne]=1", "id <> $1::bigint", []any{"1"}},
+		{"id[gt]=1", "id > $1::bigint", []any{"1"}},
+		{"id[gte]=1", "id >= $1::bigint", []any{"1"}},
+		{"id[lt]=1", "id < $1::bigint", []any{"1"}},
+		{"id[lte]=1", "id <= $1::bigint", []any{"1"}},
+		{"id[in]=1,2,3", "id = ANY($1::bigint[])", []any{pq.Array([]string{"1", "2", "3"})}},
+		{"value[gt]=1.5", "example_value_1 > $1::numeric", []any{"1.5"}},
+		{"activated=true", "activated = $1::boolean", []any{"true"}},
+		{"created_at[gt]=2024-01-02T03:04:05Z", "created_at > $1::timestamptz", []any{"2024-01-02T03:04:05Z"}},
+		// text comparisons are case-insensitive
+		{"name=Bob", "LOWER(name) = $1::text", []any{"bob"}},
+		{"name[contains]=Bo", "LOWER(name) LIKE '%' || $1 || '%'", []any{"bo"}},
+		{"name[in]=Bob,ALICE", "LOWER(name) = ANY($1::text[])", []any{pq.Array([]string{"bob", "alice"})}},
+		// conditions are joined in the order of the sorted keys
+		{"name=bob&id[gt]=1", "id > $1::bigint AND LOWER(name) = $2::text", []any{"1", "bob"}},
+		// a repeated key is a condition for each value
+		{"id[gt]=1&id[gt]=2", "id > $1::bigint AND id > $2::bigint", []any{"1", "2"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			f, v := parseTestConditions(t, tt.query)
+			if !v.Valid() {
+				t.Fatalf("ParseConditions() errors = %v", v.Errors)
+			}
+
+			args := []any{}
+
+			if sql := f.where(&args); sql != tt.sql {
+				t.Errorf("where() = %q, want %q", sql, tt.sql)
+			}
+
+			if !reflect.DeepEqual(args, tt.args) {
+				t.Errorf("where() args = %#v, want %#v", args, tt.args)
+			}
+		})
+	}
+}
+
+// the placeholders continue after the args of the query
+func TestFiltersWhereExistingArgs(t *testing.T) {
+	f, _ := parseTestConditions(t, "id=1")
+
+	args := []any{"a", "b"}
+
+	if sql := f.where(&args); sql != "id = $3::bigint" {
+		t.Errorf("where() = %q, want %q", sql, "id = $3::bigint")
+	}
+}
+
+func TestParseConditionsErrors(t *testing.T) {
+	tests := []struct {
+		query string
+		key   string
+	}{
+		{"unknown[eq]=1", "unknown[eq]"},
+		{"id[like]=1", "id[like]"},
+		{"activated[ne]=true", "activated[ne]"},
+		{"id[eq=1", "id[eq"},
+		{"id[EQ]=1", "id[EQ]"},
+		{"id=abc", "id"},
+		{"id[in]=1,x", "id[in]"},
+		{"value=abc", "value"},
+		{"activated=yes", "activated"},
+		{"created_at[gt]=2024-01-02", "created_at[gt]"},
+		{"id[in]=" + strings.Repeat("1,", maxFilterInValues) + "1", "id[in]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			_, v := parseTestConditions(t, tt.query)
+
+			if _, found := v.Errors[tt.key]; !found {
+				t.Errorf("ParseConditions() errors = %v, want an error for %q", v.Errors, tt.key)
+			}
+		})
+	}
+}
+
+func TestParseConditionsInValues(t *testing.T) {
+	values := make([]string, maxFilterInValues)
+	for i := range values {
+		values[i] = strconv.Itoa(i)
+	}
+
+	f, v := parseTestConditions(t, "id[in]="+strings.Join(values, ","))
+	if !v.Valid() {
+		t.Fatalf("ParseConditions() errors = %v", v.Errors)
+	}
+
+	if len(f.Conditions) != 1 || !reflect.DeepEqual(f.Conditions[0].Values, values) {
+		t.Errorf("ParseConditions() conditions = %v, want one in condition with %d values", f.Conditions, maxFilterInValues)
+	}
+}
+
+// keys that are not safelisted fields, like the pagination and sort parameters, are not filters
+func TestParseConditionsIgnoresOtherKeys(t *testing.T) {
+	f, v := parseTestConditions(t, "page=2&page_size=10&sort=-id&q=text&after=cursor&fields=id")
+
+	if !v.Valid() {
+		t.Errorf("ParseConditions() errors = %v", v.Errors)
+	}
+
+	if len(f.Conditions) != 0 {
+		t.Errorf("ParseConditions() conditions = %v, want none", f.Conditions)
+	}
+}
+
+// a "+" of a time offset sent unescaped arrives as a space
+func TestParseConditionsTimeOffset(t *testing.T) {
+	for _, query := range []string{"created_at[gt]=2024-01-02T03:04:05+02:00", "created_at[gt]=2024-01-02T03:04:05%2B02:00"} {
+		f, v := parseTestConditions(t, query)
+		if !v.Valid() {
+			t.Fatalf("ParseConditions(%s) errors = %v", query, v.Errors)
+		}
+
+		if got := f.Conditions[0].Values[0]; got != "2024-01-02T03:04:05+02:00" {
+			t.Errorf("ParseConditions(%s) value = %q, want %q", query, got, "2024-01-02T03:04:05+02:00")
+		}
+	}
+}
+
+func TestEscapeLike(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"plain", "plain"},
+		{"100%", `100\%`},
+		{"a_b", `a\_b`},
+		{`a\b`, `a\\b`},
+		{`%_\`, `\%\_\\`},
+	}
+
+	for _, tt := range tests {
+		if got := escapeLike(tt.value); got != tt.want {
+			t.Errorf("escapeLike(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+
+	f, _ := parseTestConditions(t, "name[contains]="+url.QueryEscape(`50%_\`))
+	args := []any{}
+	f.where(&args)
+
+	if want := []any{`50\%\_\\`}; !reflect.DeepEqual(args, want) {
+		t.Errorf("where() args = %#v, want %#v", args, want)
+	}
+}
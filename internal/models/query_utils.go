@@ -1,29 +1,61 @@
 package models
 
 import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"math"
 	"strings"
+	"time"
+
+	"github.com/lib/pq"
 
 	"go.api.template/internal/validator"
 )
 
+var ErrInvalidCursor = errors.New("invalid pagination cursor")
+
 type Filters struct {
-	Page          int
-	PageSize      int
-	SortColumn    string
-	SortDirection string
-	SortSafelist  []string
+	Page         int
+	PageSize     int
+	Sort         []SortKey
+	SortSafelist []string
+	Conditions   []FilterCondition
+	// opaque cursors for keyset pagination, the page starts after or ends before the row they point to
+	After  string
+	Before string
+	cursor *cursor
+}
+
+// a sort column and its direction ("ASC" or "DESC")
+type SortKey struct {
+	Column    string
+	Direction string
 }
 
-// init filters instance
-func InitFilters(page int, pageSize int, sortField string, sortSafelist []string) *Filters {
-	return &Filters{
-		Page:          page,
-		PageSize:      pageSize,
-		SortColumn:    sortColumn(sortField),
-		SortDirection: sortDirection(sortField),
-		SortSafelist:  sortSafelist,
+// position of a row in a sorted list, the sort it belongs to and the sort keys values of the row
+type cursor struct {
+	Sort string `json:"s"`
+	Keys []any  `json:"k"`
+}
+
+// init filters instance, sort is a comma separated list of columns, "-" prefixed for descending order
+func InitFilters(page int, pageSize int, sort string, sortSafelist []string) *Filters {
+	filters := &Filters{
+		Page:         page,
+		PageSize:     pageSize,
+		SortSafelist: sortSafelist,
+	}
+
+	for _, sortField := range strings.Split(sort, ",") {
+		filters.Sort = append(filters.Sort, SortKey{Column: sortColumn(sortField), Direction: sortDirection(sortField)})
 	}
+
+	return filters
 }
 
 // return the sort column
@@ -40,16 +72,316 @@ func sortDirection(sortField string) string {
 	return "ASC"
 }
 
-// return LIMIT value for pagination
+// check if the results are sorted by a column
+func (f *Filters) SortedBy(column string) bool {
+	for _, key := range f.Sort {
+		if key.Column == column {
+			return true
+		}
+	}
+
+	return false
+}
+
+// return the sort as it is sent by the client, it identifies the sort a cursor belongs to
+func (f *Filters) sort() string {
+	fields := make([]string, len(f.Sort))
+
+	for i, key := range f.Sort {
+		fields[i] = key.Column
+		if key.Direction == "DESC" {
+			fields[i] = "-" + key.Column
+		}
+	}
+
+	return strings.Join(fields, ",")
+}
+
+// return the SQL sort keys, the sort columns (or the expressions that replace them) followed by id,
+// the tie-breaker that gives every row an unique position
+func (f *Filters) sortKeys(expressions map[string]string) []SortKey {
+	keys := []SortKey{}
+
+	for _, key := range f.Sort {
+		if expression, found := expressions[key.Column]; found {
+			key.Column = expression
+		}
+
+		keys = append(keys, key)
+	}
+
+	if !f.SortedBy("id") {
+		keys = append(keys, SortKey{Column: "id", Direction: "ASC"})
+	}
+
+	return keys
+}
+
+// check if the keyset pagination (After or Before) is used instead of the page number
+func (f *Filters) keysetPagination() bool {
+	return f.cursor != nil
+}
+
+// return LIMIT value for pagination, the keyset pagination fetches an extra row to know if there are more pages
 func (f *Filters) limit() int {
+	if f.keysetPagination() {
+		return f.PageSize + 1
+	}
+
 	return f.PageSize
 }
 
 // return OFFSET value for pagination
 func (f *Filters) offset() int {
+	if f.keysetPagination() {
+		return 0
+	}
+
 	return (f.Page - 1) * f.PageSize
 }
 
+// return the column that selects the total number of records, the keyset pagination does not count them as counting is slow on big tables
+func (f *Filters) countColumn() string {
+	if f.keysetPagination() {
+		return "0"
+	}
+
+	return "count(*) OVER()"
+}
+
+// build the ORDER BY of a query, the keyset pagination WHERE condition ("TRUE" without a cursor),
+// and the sort keys columns to select, their values build the cursors (see paginate).
+// expressions replace the sort columns that are not table columns, the cursor values are added to args.
+// a Before page is fetched in reverse order, paginate restores it.
+func (f *Filters) keyset(expressions map[string]string, args *[]any) (string, string, string) {
+	keys := f.sortKeys(expressions)
+
+	columns := make([]string, len(keys))
+	for i, key := range keys {
+		columns[i] = key.Column
+	}
+
+	if f.Before != "" {
+		for i := range keys {
+			keys[i].Direction = reverse(keys[i].Direction)
+		}
+	}
+
+	orderBy := make([]string, len(keys))
+	for i, key := range keys {
+		orderBy[i] = fmt.Sprintf("%s %s", key.Column, key.Direction)
+	}
+
+	if f.cursor == nil || len(f.cursor.Keys) != len(keys) {
+		return strings.Join(orderBy, ", "), "TRUE", strings.Join(columns, ", ")
+	}
+
+	// a row is after the cursor if it is after it on the first key that differs:
+	// (k1 > v1) OR (k1 = v1 AND k2 > v2) OR (k1 = v1 AND k2 = v2 AND k3 > v3) ...
+	positions := make([]int, len(keys))
+	for i, value := range f.cursor.Keys {
+		*args = append(*args, value)
+		positions[i] = len(*args)
+	}
+
+	conditions := make([]string, len(keys))
+	for i, key := range keys {
+		terms := []string{}
+
+		for j := 0; j < i; j++ {
+			terms = append(terms, fmt.Sprintf("%s = $%d", keys[j].Column, positions[j]))
+		}
+
+		terms = append(terms, fmt.Sprintf("%s %s $%d", key.Column, comparison(key.Direction), positions[i]))
+		conditions[i] = "(" + strings.Join(terms, " AND ") + ")"
+	}
+
+	return strings.Join(orderBy, ", "), "(" + strings.Join(conditions, " OR ") + ")", strings.Join(columns, ", ")
+}
+
+// number of sort keys columns selected by the keyset query
+func (f *Filters) sortKeysCount() int {
+	return len(f.sortKeys(nil))
+}
+
+// return the scan destinations of the sort keys values of a row
+func scanKeys(keys []any) []any {
+	dest := make([]any, len(keys))
+	for i := range keys {
+		dest[i] = &keys[i]
+	}
+
+	return dest
+}
+
+// return the opposite sort direction
+func reverse(direction string) string {
+	if direction == "DESC" {
+		return "ASC"
+	}
+
+	return "DESC"
+}
+
+// return the operator that selects the rows after a value in a sort direction
+func comparison(direction string) string {
+	if direction == "DESC" {
+		return "<"
+	}
+
+	return ">"
+}
+
+// encode an opaque cursor that points to a row, keys are the values of its sort keys
+func (f *Filters) encodeCursor(keys []any) string {
+	values := make([]any, len(keys))
+
+	for i, key := range keys {
+		values[i] = key
+
+		// text-like values (numeric, citext) are scanned as bytes
+		if b, ok := key.([]byte); ok {
+			values[i] = string(b)
+		}
+	}
+
+	js, err := json.Marshal(cursor{Sort: f.sort(), Keys: values})
+	if err != nil {
+		return ""
+	}
+
+	return base64.RawURLEncoding.EncodeToString(js)
+}
+
+// decode an opaque cursor, it must belong to the current sort
+func (f *Filters) decodeCursor(value string) (*cursor, error) {
+	js, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var c cursor
+
+	// numbers are kept as text, postgres converts them to the type of the sort column
+	dec := json.NewDecoder(bytes.NewReader(js))
+	dec.UseNumber()
+
+	err = dec.Decode(&c)
+	if err != nil || c.Sort != f.sort() || len(c.Keys) != f.sortKeysCount() {
+		return nil, ErrInvalidCursor
+	}
+
+	return &c, nil
+}
+
+// check if a query error is caused by a cursor value that does not fit the sort column type (a tampered cursor)
+func isInvalidCursorValue(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code.Class() == "22"
+}
+
+// trim the extra row fetched by the keyset pagination, restore the order of a Before page and build the metadata.
+// keys are the sort keys values of the rows.
+func paginate[T any](f *Filters, rows []T, keys [][]any, totalRecords int) ([]T, *Metadata) {
+	if !f.keysetPagination() {
+		metadata := InitMetadata(totalRecords, f.Page, f.PageSize)
+
+		// the cursor of the last row lets the client continue with the keyset pagination
+		if len(rows) > 0 && f.Page < metadata.LastPage {
+			metadata.NextCursor = f.encodeCursor(keys[len(rows)-1])
+		}
+
+		return rows, metadata
+	}
+
+	more := len(rows) > f.PageSize
+	if more {
+		rows, keys = rows[:f.PageSize], keys[:f.PageSize]
+	}
+
+	if f.Before != "" {
+		for i, j := 0, len(rows)-1; i < j; i, j = i+1, j-1 {
+			rows[i], rows[j] = rows[j], rows[i]
+			keys[i], keys[j] = keys[j], keys[i]
+		}
+	}
+
+	metadata := &Metadata{PageSize: f.PageSize}
+
+	if len(rows) == 0 {
+		return rows, metadata
+	}
+
+	first, last := f.encodeCursor(keys[0]), f.encodeCursor(keys[len(rows)-1])
+
+	// the page was reached from the cursor, so there are rows on the other side of it
+	if f.After != "" {
+		metadata.PrevCursor = first
+		if more {
+			metadata.NextCursor = last
+		}
+	} else {
+		metadata.NextCursor = last
+		if more {
+			metadata.PrevCursor = first
+		}
+	}
+
+	return rows, metadata
+}
+
+// check that the sort safelists only contain columns of their tables (besides the computed sort keys),
+// so an invalid sort key fails at startup instead of on a request
+func CheckSortColumns(db *sql.DB) error {
+	safelists := map[string][]string{
+		"examples": ExampleSortColumns,
+		"users":    UserSortColumns,
+	}
+
+	query := `
+        SELECT column_name
+        FROM information_schema.columns
+        WHERE table_schema = current_schema() AND table_name = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	for table, safelist := range safelists {
+		rows, err := db.QueryContext(ctx, query, table)
+		if err != nil {
+			return err
+		}
+
+		columns := []string{}
+
+		for rows.Next() {
+			var column string
+
+			err = rows.Scan(&column)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+
+			columns = append(columns, column)
+		}
+
+		rows.Close()
+
+		if err = rows.Err(); err != nil {
+			return err
+		}
+
+		for _, column := range safelist {
+			if !validator.PermittedValue(column, columns...) {
+				return fmt.Errorf("sort column %s is not a column of the %s table", column, table)
+			}
+		}
+	}
+
+	return nil
+}
+
 // validate input filters fields
 func (f *Filters) ValidateFilters(v *validator.Validator) {
 	v.Check(validator.MinNumber(f.Page, 1), "page", "must be greater than zero")
@@ -57,15 +389,44 @@ func (f *Filters) ValidateFilters(v *validator.Validator) {
 	v.Check(validator.MinNumber(f.PageSize, 1), "page_size", "must be greater than zero")
 	v.Check(validator.MaxNumber(f.PageSize, 100), "page_size", "must be a maximum of 100")
 
-	v.Check(validator.PermittedValue(f.SortColumn, f.SortSafelist...), "sort", "invalid sort value")
+	columns := make([]string, len(f.Sort))
+	for i, key := range f.Sort {
+		v.Check(validator.PermittedValue(key.Column, f.SortSafelist...), "sort", fmt.Sprintf("invalid sort value %q", key.Column))
+		columns[i] = key.Column
+	}
+	v.Check(validator.Unique(columns), "sort", "must not contain duplicate values")
+
+	v.Check(f.After == "" || f.Before == "", "after", "cannot be used with before")
+	v.Check(f.Page == 1 || (f.After == "" && f.Before == ""), "page", "cannot be used with after or before")
+
+	for key, value := range map[string]string{"after": f.After, "before": f.Before} {
+		if value == "" || !v.Valid() {
+			continue
+		}
+
+		c, err := f.decodeCursor(value)
+		if err != nil {
+			v.AddError(key, "invalid cursor, or cursor of another sort")
+			continue
+		}
+
+		f.cursor = c
+	}
 }
 
 type Metadata struct {
-	CurrentPage  int `json:"current_page,omitempty"`
-	PageSize     int `json:"page_size,omitempty"`
-	FirstPage    int `json:"first_page,omitempty"`
-	LastPage     int `json:"last_page,omitempty"`
-	TotalRecords int `json:"total_records,omitempty"`
+	CurrentPage  int    `json:"current_page,omitempty"`
+	PageSize     int    `json:"page_size,omitempty"`
+	FirstPage    int    `json:"first_page,omitempty"`
+	LastPage     int    `json:"last_page,omitempty"`
+	TotalRecords int    `json:"total_records,omitempty"`
+	NextCursor   string `json:"next_cursor,omitempty"`
+	PrevCursor   string `json:"prev_cursor,omitempty"`
+	// navigation links, the URLs of the other pages with the same query filters
+	Next  string `json:"next,omitempty"`
+	Prev  string `json:"prev,omitempty"`
+	First string `json:"first,omitempty"`
+	Last  string `json:"last,omitempty"`
 }
 
 // init metadata instance
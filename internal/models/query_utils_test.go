@@ -0,0 +1,328 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"go.api.template/internal/validator"
+)
+
+var testSortSafelist = []string{"id", "name", "created_at", "relevance"}
+
+// init and validate filters, the test fails on validation errors
+func newTestFilters(t *testing.T, sort, after, before string) *Filters {
+	t.Helper()
+
+	f := InitFilters(1, 2, sort, testSortSafelist)
+	f.After = after
+	f.Before = before
+
+	v := &validator.Validator{}
+	f.ValidateFilters(v)
+
+	if !v.Valid() {
+		t.Fatalf("ValidateFilters() errors = %v", v.Errors)
+	}
+
+	return f
+}
+
+func TestInitFiltersSort(t *testing.T) {
+	f := InitFilters(1, 20, "-name,created_at,-id", testSortSafelist)
+
+	want := []SortKey{{"name", "DESC"}, {"created_at", "ASC"}, {"id", "DESC"}}
+	if !reflect.DeepEqual(f.Sort, want) {
+		t.Errorf("InitFilters() sort = %v, want %v", f.Sort, want)
+	}
+
+	if got := f.sort(); got != "-name,created_at,-id" {
+		t.Errorf("sort() = %q, want %q", got, "-name,created_at,-id")
+	}
+}
+
+func TestValidateFiltersSort(t *testing.T) {
+	tests := []struct {
+		sort  string
+		valid bool
+	}{
+		{"id", true},
+		{"-name,created_at", true},
+		{"unknown", false},
+		{"name,", false},
+		{"name,-name", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.sort, func(t *testing.T) {
+			v := &validator.Validator{}
+			InitFilters(1, 20, tt.sort, testSortSafelist).ValidateFilters(v)
+
+			if v.Valid() != tt.valid {
+				t.Errorf("ValidateFilters() errors = %v, want valid %v", v.Errors, tt.valid)
+			}
+		})
+	}
+}
+
+func TestKeysetOrderBy(t *testing.T) {
+	tests := []struct {
+		sort    string
+		orderBy string
+		columns string
+	}{
+		{"id", "id ASC", "id"},
+		{"-id", "id DESC", "id"},
+		{"name", "name ASC, id ASC", "name, id"},
+		{"-name", "name DESC, id ASC", "name, id"},
+		{"-name,created_at", "name DESC, created_at ASC, id ASC", "name, created_at, id"},
+		// id is not appended when the sort already contains it
+		{"name,-id", "name ASC, id DESC", "name, id"},
+		{"-id,name", "id DESC, name ASC", "id, name"},
+		// expressions replace the sort columns that are not table columns
+		{"-relevance", "rank($1) DESC, id ASC", "rank($1), id"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.sort, func(t *testing.T) {
+			f := newTestFilters(t, tt.sort, "", "")
+			args := []any{}
+
+			orderBy, condition, columns := f.keyset(map[string]string{"relevance": "rank($1)"}, &args)
+
+			if orderBy != tt.orderBy {
+				t.Errorf("keyset() order by = %q, want %q", orderBy, tt.orderBy)
+			}
+
+			if condition != "TRUE" || len(args) != 0 {
+				t.Errorf("keyset() condition = %q args = %v, want TRUE without args", condition, args)
+			}
+
+			if columns != tt.columns {
+				t.Errorf("keyset() columns = %q, want %q", columns, tt.columns)
+			}
+		})
+	}
+}
+
+func TestKeysetCondition(t *testing.T) {
+	tests := []struct {
+		name      string
+		sort      string
+		keys      []any
+		before    bool
+		orderBy   string
+		condition string
+	}{
+		{
+			name:      "one key after",
+			sort:      "id",
+			keys:      []any{5},
+			orderBy:   "id ASC",
+			condition: "((id > $2))",
+		},
+		{
+			name:      "one key before",
+			sort:      "id",
+			keys:      []any{5},
+			before:    true,
+			orderBy:   "id DESC",
+			condition: "((id < $2))",
+		},
+		{
+			name:      "two keys after",
+			sort:      "-name",
+			keys:      []any{"bob", 5},
+			orderBy:   "name DESC, id ASC",
+			condition: "((name < $2) OR (name = $2 AND id > $3))",
+		},
+		{
+			name:      "two keys before",
+			sort:      "-name",
+			keys:      []any{"bob", 5},
+			before:    true,
+			orderBy:   "name ASC, id DESC",
+			condition: "((name > $2) OR (name = $2 AND id < $3))",
+		},
+		{
+			name:      "three keys after",
+			sort:      "name,-created_at",
+			keys:      []any{"bob", "2024-01-01T00:00:00Z", 5},
+			orderBy:   "name ASC, created_at DESC, id ASC",
+			condition: "((name > $2) OR (name = $2 AND created_at < $3) OR (name = $2 AND created_at = $3 AND id > $4))",
+		},
+		{
+			name:      "three keys before",
+			sort:      "name,-created_at",
+			keys:      []any{"bob", "2024-01-01T00:00:00Z", 5},
+			before:    true,
+			orderBy:   "name DESC, created_at ASC, id DESC",
+			condition: "((name < $2) OR (name = $2 AND created_at > $3) OR (name = $2 AND created_at = $3 AND id < $4))",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cursor := InitFilters(1, 2, tt.sort, testSortSafelist).encodeCursor(tt.keys)
+
+			after, before := cursor, ""
+			if tt.before {
+				after, before = "", cursor
+			}
+
+			f := newTestFilters(t, tt.sort, after, before)
+
+			// the placeholders continue after the args of the query
+			args := []any{"arg"}
+
+			orderBy, condition, _ := f.keyset(nil, &args)
+
+			if orderBy != tt.orderBy {
+				t.Errorf("keyset() order by = %q, want %q", orderBy, tt.orderBy)
+			}
+
+			if condition != tt.condition {
+				t.Errorf("keyset() condition = %q, want %q", condition, tt.condition)
+			}
+
+			if len(args) != 1+len(tt.keys) {
+				t.Errorf("keyset() args = %v, want the %d cursor keys after the query arg", args, len(tt.keys))
+			}
+		})
+	}
+}
+
+func TestDecodeCursor(t *testing.T) {
+	f := InitFilters(1, 2, "-name", testSortSafelist)
+
+	got, err := f.decodeCursor(f.encodeCursor([]any{[]byte("bob"), int64(5)}))
+	if err != nil {
+		t.Fatalf("decodeCursor() error = %v", err)
+	}
+
+	// bytes are encoded as text, numbers are kept as text for postgres to convert them
+	if want := []any{"bob", json.Number("5")}; got.Sort != "-name" || !reflect.DeepEqual(got.Keys, want) {
+		t.Errorf("decodeCursor() = %+v, want sort -name and keys %v", got, want)
+	}
+
+	tests := []struct {
+		name   string
+		cursor string
+	}{
+		{"another sort", InitFilters(1, 2, "name", testSortSafelist).encodeCursor([]any{"bob", 5})},
+		{"another sort with the same keys", InitFilters(1, 2, "-created_at", testSortSafelist).encodeCursor([]any{"bob", 5})},
+		{"too few keys", f.encodeCursor([]any{"bob"})},
+		{"too many keys", f.encodeCursor([]any{"bob", 5, 6})},
+		{"not base64", "!!!"},
+		{"not json", "bm90IGpzb24"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := f.decodeCursor(tt.cursor); !errors.Is(err, ErrInvalidCursor) {
+				t.Errorf("decodeCursor() error = %v, want %v", err, ErrInvalidCursor)
+			}
+
+			v := &validator.Validator{}
+			g := InitFilters(1, 2, "-name", testSortSafelist)
+			g.After = tt.cursor
+			g.ValidateFilters(v)
+
+			if _, found := v.Errors["after"]; !found {
+				t.Errorf("ValidateFilters() errors = %v, want an after error", v.Errors)
+			}
+		})
+	}
+}
+
+func TestPaginate(t *testing.T) {
+	// the sort keys of row n are {n}
+	rows := func(ids ...int) ([]int, [][]any) {
+		keys := make([][]any, len(ids))
+		for i, id := range ids {
+			keys[i] = []any{id}
+		}
+		return ids, keys
+	}
+
+	f := InitFilters(1, 2, "id", testSortSafelist)
+	cursor := func(id int) string { return f.encodeCursor([]any{id}) }
+
+	tests := []struct {
+		name   string
+		after  int
+		before int
+		rows   []int
+		want   []int
+		next   string
+		prev   string
+	}{
+		{name: "after, more rows", after: 2, rows: []int{3, 4, 5}, want: []int{3, 4}, next: cursor(4), prev: cursor(3)},
+		{name: "after, last page", after: 2, rows: []int{3, 4}, want: []int{3, 4}, prev: cursor(3)},
+		{name: "after, empty page", after: 9, rows: []int{}, want: []int{}},
+		// a before page is fetched in reverse order
+		{name: "before, more rows", before: 5, rows: []int{4, 3, 2}, want: []int{3, 4}, next: cursor(4), prev: cursor(3)},
+		{name: "before, first page", before: 3, rows: []int{2, 1}, want: []int{1, 2}, next: cursor(2)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var after, before string
+			if tt.after != 0 {
+				after = cursor(tt.after)
+			}
+			if tt.before != 0 {
+				before = cursor(tt.before)
+			}
+
+			f := newTestFilters(t, "id", after, before)
+			ids, keys := rows(tt.rows...)
+
+			got, metadata := paginate(f, ids, keys, 0)
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("paginate() rows = %v, want %v", got, tt.want)
+			}
+
+			if metadata.NextCursor != tt.next {
+				t.Errorf("paginate() next cursor = %q, want %q", metadata.NextCursor, tt.next)
+			}
+
+			if metadata.PrevCursor != tt.prev {
+				t.Errorf("paginate() prev cursor = %q, want %q", metadata.PrevCursor, tt.prev)
+			}
+
+			if metadata.PageSize != 2 || metadata.TotalRecords != 0 {
+				t.Errorf("paginate() metadata = %+v, want page size 2 without total", metadata)
+			}
+		})
+	}
+}
+
+// the page number pagination returns the rows as they are, with the cursor of the last one unless it is the last page
+func TestPaginateOffset(t *testing.T) {
+	f := newTestFilters(t, "id", "", "")
+
+	got, metadata := paginate(f, []int{1, 2}, [][]any{{1}, {2}}, 5)
+
+	if !reflect.DeepEqual(got, []int{1, 2}) {
+		t.Errorf("paginate() rows = %v, want [1 2]", got)
+	}
+
+	if metadata.LastPage != 3 || metadata.TotalRecords != 5 {
+		t.Errorf("paginate() metadata = %+v, want 3 pages of 5 records", metadata)
+	}
+
+	if want := f.encodeCursor([]any{2}); metadata.NextCursor != want {
+		t.Errorf("paginate() next cursor = %q, want %q", metadata.NextCursor, want)
+	}
+
+	f = InitFilters(3, 2, "id", testSortSafelist)
+
+	_, metadata = paginate(f, []int{5}, [][]any{{5}}, 5)
+
+	if metadata.NextCursor != "" {
+		t.Errorf("paginate() next cursor on the last page = %q, want none", metadata.NextCursor)
+	}
+}
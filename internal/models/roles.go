@@ -0,0 +1,103 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// roles created by the migrations, every new user gets RoleUser
+const (
+	RoleUser   = "user"
+	RoleEditor = "editor"
+)
+
+type Roles []string
+
+type RolesDBConnection struct {
+	DB    *sql.DB
+	Cache *PermissionsCache
+}
+
+// get all the roles of an specific user
+func (ro RolesDBConnection) GetAllForUser(userID int64) (Roles, error) {
+	query := `
+        SELECT roles.code
+        FROM roles
+        INNER JOIN users_roles ON users_roles.role_id = roles.id
+        WHERE users_roles.user_id = $1
+        ORDER BY roles.code`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := ro.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	roles := Roles{}
+
+	for rows.Next() {
+		var role string
+
+		err := rows.Scan(&role)
+		if err != nil {
+			return nil, err
+		}
+
+		roles = append(roles, role)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return roles, nil
+}
+
+// add roles to an user
+func (ro RolesDBConnection) AddForUser(userID int64, codes ...string) error {
+	query := `
+        INSERT INTO users_roles
+        SELECT $1, roles.id FROM roles WHERE roles.code = ANY($2)
+        ON CONFLICT DO NOTHING`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := ro.DB.ExecContext(ctx, query, userID, pq.Array(codes))
+	if err != nil {
+		return err
+	}
+
+	ro.Cache.Invalidate(userID)
+
+	return nil
+}
+
+// remove roles from an user
+func (ro RolesDBConnection) RemoveForUser(userID int64, codes ...string) error {
+	query := `
+        DELETE FROM users_roles
+        USING roles
+        WHERE users_roles.role_id = roles.id
+        AND users_roles.user_id = $1
+        AND roles.code = ANY($2)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := ro.DB.ExecContext(ctx, query, userID, pq.Array(codes))
+	if err != nil {
+		return err
+	}
+
+	ro.Cache.Invalidate(userID)
+
+	return nil
+}
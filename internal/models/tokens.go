@@ -10,12 +10,19 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/lib/pq"
+
 	"go.api.template/internal/validator"
 )
 
 const (
 	ScopeActivation     = "activation"
 	ScopeAuthentication = "authentication"
+	ScopePasswordReset  = "password-reset"
+	ScopeRefresh        = "refresh"
+	ScopeTwoFactor      = "two-factor"
+	ScopeUnlock         = "unlock"
+	ScopeEmailChange    = "email-change"
 )
 
 var (
@@ -26,6 +33,7 @@ var (
 		return errors.New("invalid or missing authentication token")
 	}
 	ErrAuthenticationRequired = errors.New("authentication required")
+	ErrRefreshTokenReused     = errors.New("refresh token has already been used, the session has been revoked")
 )
 
 type Token struct {
@@ -34,6 +42,8 @@ type Token struct {
 	UserID    int64     `json:"-"`
 	Expiry    time.Time `json:"expiry"`
 	Scope     string    `json:"-"`
+	Family    []byte    `json:"-"`
+	Used      bool      `json:"-"`
 }
 
 type TokenDBConnection struct {
@@ -81,13 +91,32 @@ func ValidateTokenPlaintext(tokenPlaintext string) *validator.Validator {
 	return v
 }
 
+// generate a random identifier shared by all the tokens issued from the same login
+func NewTokenFamily() ([]byte, error) {
+	family := make([]byte, 16)
+
+	_, err := rand.Read(family)
+	if err != nil {
+		return nil, err
+	}
+
+	return family, nil
+}
+
 // generate and insert a token in db
 func (t TokenDBConnection) InitToken(userID int64, ttl time.Duration, scope string) (*Token, error) {
+	return t.InitFamilyToken(userID, ttl, scope, nil)
+}
+
+// generate and insert a token that belongs to a token family in db
+func (t TokenDBConnection) InitFamilyToken(userID int64, ttl time.Duration, scope string, family []byte) (*Token, error) {
 	token, err := generateToken(userID, ttl, scope)
 	if err != nil {
 		return nil, err
 	}
 
+	token.Family = family
+
 	err = t.Insert(token)
 	if err != nil {
 		return nil, err
@@ -99,10 +128,10 @@ func (t TokenDBConnection) InitToken(userID int64, ttl time.Duration, scope stri
 // insert token in db
 func (t TokenDBConnection) Insert(token *Token) error {
 	query := `
-        INSERT INTO tokens (hash, user_id, expiry, scope) 
-        VALUES ($1, $2, $3, $4)`
+        INSERT INTO tokens (hash, user_id, expiry, scope, family) 
+        VALUES ($1, $2, $3, $4, $5)`
 
-	args := []any{token.Hash, token.UserID, token.Expiry, token.Scope}
+	args := []any{token.Hash, token.UserID, token.Expiry, token.Scope, token.Family}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -115,7 +144,7 @@ func (t TokenDBConnection) Insert(token *Token) error {
 // get token by hash and scope, that has not been expiry
 func (t TokenDBConnection) GetActiveToken(token *Token) error {
 	query := `
-		SELECT user_id, expiry FROM tokens
+		SELECT user_id, expiry, family, used FROM tokens
 		WHERE hash = $1
 		AND scope = $2
 		AND expiry > $3`
@@ -125,7 +154,9 @@ func (t TokenDBConnection) GetActiveToken(token *Token) error {
 
 	err := t.DB.QueryRowContext(ctx, query, token.Hash, token.Scope, time.Now()).Scan(
 		&token.UserID,
-		&token.Expiry)
+		&token.Expiry,
+		&token.Family,
+		&token.Used)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return ErrTokenRecordNotFoundOrExpiry
@@ -150,3 +181,85 @@ func (t TokenDBConnection) DeleteAllForUser(scope string, userID int64) error {
 
 	return err
 }
+
+// mark a token as used, fails with ErrRefreshTokenReused if it was already used
+func (t TokenDBConnection) MarkUsed(token *Token) error {
+	query := `
+        UPDATE tokens 
+        SET used = true
+        WHERE hash = $1 AND scope = $2 AND used = false`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := t.DB.ExecContext(ctx, query, token.Hash, token.Scope)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRefreshTokenReused
+	}
+
+	return nil
+}
+
+// delete a token by its plaintext and scope, together with every token of its family
+func (t TokenDBConnection) DeleteWithFamily(scope string, tokenPlaintext string) error {
+	query := `
+        DELETE FROM tokens 
+        WHERE (hash = $1 AND scope = $2)
+        OR family = (SELECT family FROM tokens WHERE hash = $1 AND scope = $2)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := t.DB.ExecContext(ctx, query, HashToken(tokenPlaintext), scope)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrTokenRecordNotFoundOrExpiry
+	}
+
+	return nil
+}
+
+// delete all the tokens issued from the same login
+func (t TokenDBConnection) DeleteFamily(family []byte) error {
+	query := `
+        DELETE FROM tokens 
+        WHERE family = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := t.DB.ExecContext(ctx, query, family)
+
+	return err
+}
+
+// delete all the authentication and refresh tokens of an user, ending every session
+func (t TokenDBConnection) DeleteAllSessionsForUser(userID int64) error {
+	query := `
+        DELETE FROM tokens 
+        WHERE scope = ANY($1) AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := t.DB.ExecContext(ctx, query, pq.Array([]string{ScopeAuthentication, ScopeRefresh}), userID)
+
+	return err
+}
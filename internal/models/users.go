@@ -6,8 +6,10 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
+	"github.com/lib/pq"
 	"go.api.template/internal/validator"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -18,18 +20,34 @@ var (
 	ErrInvalidCredentials = errors.New("invalid credential")
 	ErrInactiveUser       = errors.New("inactive user")
 
+	ErrNoUserFieldsToUpdate  = errors.New("no user fields to update")
+	ErrUserFieldNotUpdatable = errors.New("user field cannot be updated")
+
 	AnonymousUser = &User{}
 )
 
 type User struct {
-	ID        int64     `json:"id"`
-	CreatedAt time.Time `json:"created_at"`
-	Name      string    `json:"name"`
-	Email     string    `json:"email"`
-	Password  password  `json:"-"`
-	Activated bool      `json:"activated"`
+	ID        int64      `json:"id"`
+	CreatedAt time.Time  `json:"created_at"`
+	Name      string     `json:"name"`
+	Email     string     `json:"email"`
+	Password  password   `json:"-"`
+	Activated bool       `json:"activated"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+}
+
+// fields of the users that can be filtered on the list
+var UserFilterSafelist = FilterSafelist{
+	"id":         {Column: "id", Type: FilterTypeInteger, Operators: []string{OpEq, OpNe, OpGt, OpGte, OpLt, OpLte, OpIn}},
+	"name":       {Column: "name", Type: FilterTypeText, Operators: []string{OpEq, OpNe, OpContains}},
+	"email":      {Column: "email", Type: FilterTypeText, Operators: []string{OpEq, OpNe, OpContains, OpIn}},
+	"activated":  {Column: "activated", Type: FilterTypeBool, Operators: []string{OpEq}},
+	"created_at": {Column: "created_at", Type: FilterTypeTime, Operators: []string{OpGt, OpGte, OpLt, OpLte}},
 }
 
+// columns of the users that the list can be sorted by
+var UserSortColumns = []string{"id", "created_at", "name", "email"}
+
 type UserDBConnection struct {
 	DB *sql.DB
 }
@@ -76,15 +94,19 @@ func (u *User) IsAnonymous() bool {
 func (u *User) ValidateUser() *validator.Validator {
 	v := validator.Validator{}
 
-	v.Check(validator.NotBlank(u.Name), "name", "must be provided")
-	v.Check(validator.MaxChars(u.Name, 500), "name", "must not be more than 500 bytes long")
-
+	ValidateName(&v, u.Name)
 	ValidateEmail(&v, u.Email)
 	ValidatePassword(&v, *u.Password.plaintext)
 
 	return &v
 }
 
+// validate name
+func ValidateName(v *validator.Validator, name string) {
+	v.Check(validator.NotBlank(name), "name", "must be provided")
+	v.Check(validator.MaxChars(name, 500), "name", "must not be more than 500 bytes long")
+}
+
 // validate email
 func ValidateEmail(v *validator.Validator, email string) {
 	v.Check(validator.NotBlank(email), "email", "must be provided")
@@ -118,7 +140,7 @@ func (u UserDBConnection) Insert(user *User) error {
 	)
 	if err != nil {
 		switch {
-		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
+		case isDuplicateEmail(err):
 			return ErrDuplicateEmail
 		default:
 			return err
@@ -133,7 +155,7 @@ func (u UserDBConnection) GetByEmail(email string) (*User, error) {
 	query := `
         SELECT id, created_at, name, email, password_hash, activated
         FROM users
-        WHERE email = $1`
+        WHERE email = $1 AND deleted_at IS NULL`
 
 	var user User
 
@@ -159,6 +181,102 @@ func (u UserDBConnection) GetByEmail(email string) (*User, error) {
 	return &user, nil
 }
 
+// Get user info search by id
+func (u UserDBConnection) Get(id int64) (*User, error) {
+	query := `
+        SELECT id, created_at, name, email, password_hash, activated
+        FROM users
+        WHERE id = $1 AND deleted_at IS NULL`
+
+	var user User
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := u.DB.QueryRowContext(ctx, query, id).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrUserRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &user, nil
+}
+
+// Get all users from DB matching the filters conditions, deleted selects the deleted users instead of the active ones
+func (u UserDBConnection) GetAll(deleted bool, filters *Filters) ([]*User, *Metadata, error) {
+	totalRecords := 0
+	result := []*User{}
+	keys := [][]any{}
+
+	args := []any{deleted, filters.limit(), filters.offset()}
+
+	conditions := filters.where(&args)
+	orderBy, keyset, sortKeys := filters.keyset(nil, &args)
+
+	query := fmt.Sprintf(`
+        SELECT %s, id, created_at, name, email, activated, deleted_at, %s
+        FROM users
+        WHERE (deleted_at IS NOT NULL) = $1
+        AND %s
+        AND %s
+        ORDER BY %s
+        LIMIT $2 OFFSET $3`, filters.countColumn(), sortKeys, conditions, keyset, orderBy)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := u.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		if filters.keysetPagination() && isInvalidCursorValue(err) {
+			return nil, &Metadata{}, ErrInvalidCursor
+		}
+		return nil, &Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var user User
+		key := make([]any, filters.sortKeysCount())
+
+		dest := []any{
+			&totalRecords,
+			&user.ID,
+			&user.CreatedAt,
+			&user.Name,
+			&user.Email,
+			&user.Activated,
+			&user.DeletedAt,
+		}
+
+		err := rows.Scan(append(dest, scanKeys(key)...)...)
+		if err != nil {
+			return nil, &Metadata{}, err
+		}
+
+		result = append(result, &user)
+		keys = append(keys, key)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, &Metadata{}, err
+	}
+
+	result, metadata := paginate(filters, result, keys, totalRecords)
+
+	return result, metadata, nil
+}
+
 // Get user info by Token
 func (u UserDBConnection) GetForToken(tokenScope, tokenPlaintext string) (*User, error) {
 	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
@@ -170,7 +288,8 @@ func (u UserDBConnection) GetForToken(tokenScope, tokenPlaintext string) (*User,
         ON users.id = tokens.user_id
         WHERE tokens.hash = $1
         AND tokens.scope = $2 
-        AND tokens.expiry > $3`
+        AND tokens.expiry > $3
+        AND users.deleted_at IS NULL`
 
 	args := []any{tokenHash[:], tokenScope, time.Now()}
 
@@ -199,16 +318,48 @@ func (u UserDBConnection) GetForToken(tokenScope, tokenPlaintext string) (*User,
 	return &user, nil
 }
 
-// update a user field
-func (u UserDBConnection) UpdateField(id int64, fieldName string, value any) error {
-	query := fmt.Sprintf("UPDATE users SET %s = $1 WHERE id = $2", fieldName)
+// fields that can be changed with Update, and how to read their new value from an User.
+// column names come only from this whitelist, never from the caller.
+var userUpdatableFields = map[string]func(*User) any{
+	"name":          func(user *User) any { return user.Name },
+	"email":         func(user *User) any { return user.Email },
+	"password_hash": func(user *User) any { return user.Password.hash },
+	"activated":     func(user *User) any { return user.Activated },
+}
+
+// update some fields of an user
+func (u UserDBConnection) Update(user *User, fields ...string) error {
+	if len(fields) == 0 {
+		return ErrNoUserFieldsToUpdate
+	}
+
+	set := make([]string, len(fields))
+	args := make([]any, len(fields))
+
+	for i, field := range fields {
+		value, ok := userUpdatableFields[field]
+		if !ok {
+			return fmt.Errorf("%w: %s", ErrUserFieldNotUpdatable, field)
+		}
+
+		set[i] = fmt.Sprintf("%s = $%d", field, i+1)
+		args[i] = value(user)
+	}
+
+	query := fmt.Sprintf("UPDATE users SET %s WHERE id = $%d AND deleted_at IS NULL", strings.Join(set, ", "), len(fields)+1)
+	args = append(args, user.ID)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	result, err := u.DB.ExecContext(ctx, query, value, id)
+	result, err := u.DB.ExecContext(ctx, query, args...)
 	if err != nil {
-		return err
+		switch {
+		case isDuplicateEmail(err):
+			return ErrDuplicateEmail
+		default:
+			return err
+		}
 	}
 
 	rowsAffected, err := result.RowsAffected()
@@ -222,3 +373,99 @@ func (u UserDBConnection) UpdateField(id int64, fieldName string, value any) err
 
 	return nil
 }
+
+// mark an user as deleted, it can be restored until it is purged.
+// its tokens are deleted, so a restored user has to authenticate again.
+func (u UserDBConnection) Delete(id int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := u.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+        UPDATE users SET deleted_at = NOW()
+        WHERE id = $1 AND deleted_at IS NULL`
+
+	result, err := tx.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrUserRecordNotFound
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM tokens WHERE user_id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// restore a deleted user, it fails if its email has been taken by another user in the meantime
+func (u UserDBConnection) Restore(id int64) (*User, error) {
+	query := `
+        UPDATE users SET deleted_at = NULL
+        WHERE id = $1 AND deleted_at IS NOT NULL
+        RETURNING id, created_at, name, email, password_hash, activated`
+
+	var user User
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := u.DB.QueryRowContext(ctx, query, id).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrUserRecordNotFound
+		case isDuplicateEmail(err):
+			return nil, ErrDuplicateEmail
+		default:
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
+
+// permanently delete the users deleted before a time, its tokens, permissions and api keys are deleted on cascade
+func (u UserDBConnection) Purge(before time.Time) (int64, error) {
+	query := `
+        DELETE FROM users
+        WHERE deleted_at < $1`
+
+	// a purge can remove many rows, it gets a longer timeout than the requests queries
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	result, err := u.DB.ExecContext(ctx, query, before)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// check if the error is a violation of the unique email constraint
+func isDuplicateEmail(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Constraint == "users_email_key"
+}
@@ -0,0 +1,184 @@
+package totp
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+// RFC 6238 Appendix B seed for SHA-1
+var rfcKey = []byte("12345678901234567890")
+
+// RFC 6238 Appendix B SHA-1 test vectors, the last 6 of the 8 digits codes
+var rfcVectors = []struct {
+	unix int64
+	code string
+}{
+	{59, "287082"},
+	{1111111109, "081804"},
+	{1111111111, "050471"},
+	{1234567890, "005924"},
+	{2000000000, "279037"},
+	{20000000000, "353130"},
+}
+
+func TestGenerateCodeRFC6238(t *testing.T) {
+	for _, tt := range rfcVectors {
+		if got := generateCode(rfcKey, tt.unix/period); got != tt.code {
+			t.Errorf("generateCode(T=%d) = %s, want %s", tt.unix, got, tt.code)
+		}
+	}
+}
+
+func TestValidateRFC6238(t *testing.T) {
+	secret := encoding.EncodeToString(rfcKey)
+
+	for _, tt := range rfcVectors {
+		step, ok := Validate(tt.code, secret, time.Unix(tt.unix, 0))
+		if !ok {
+			t.Errorf("Validate(%s, T=%d) = false, want true", tt.code, tt.unix)
+			continue
+		}
+
+		if want := tt.unix / period; step != want {
+			t.Errorf("Validate(%s, T=%d) step = %d, want %d", tt.code, tt.unix, step, want)
+		}
+	}
+}
+
+func TestValidateClockDrift(t *testing.T) {
+	secret := encoding.EncodeToString(rfcKey)
+	now := time.Unix(1234567890, 0)
+	current := now.Unix() / period
+
+	tests := []struct {
+		name   string
+		offset int64
+		ok     bool
+	}{
+		{"two steps behind", -2, false},
+		{"one step behind", -1, true},
+		{"current step", 0, true},
+		{"one step ahead", 1, true},
+		{"two steps ahead", 2, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code := generateCode(rfcKey, current+tt.offset)
+
+			step, ok := Validate(code, secret, now)
+			if ok != tt.ok {
+				t.Fatalf("Validate() = %v, want %v", ok, tt.ok)
+			}
+
+			// the matched step is the one of the code, not the current one, so replays can be detected
+			if ok && step != current+tt.offset {
+				t.Errorf("Validate() step = %d, want %d", step, current+tt.offset)
+			}
+		})
+	}
+}
+
+func TestValidateRejectsInvalidInput(t *testing.T) {
+	secret := encoding.EncodeToString(rfcKey)
+	now := time.Unix(59, 0)
+
+	tests := []struct {
+		name   string
+		code   string
+		secret string
+	}{
+		{"wrong code", "000000", secret},
+		{"short code", "28708", secret},
+		{"long code", "2870820", secret},
+		{"invalid secret", "287082", "not base32!"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := Validate(tt.code, tt.secret, now); ok {
+				t.Error("Validate() = true, want false")
+			}
+		})
+	}
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+	secret := encoding.EncodeToString(rfcKey)
+
+	ciphertext, err := Encrypt(key, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := Decrypt(key, ciphertext)
+	if err != nil {
+		t.Fatalf("Decrypt() error = %v", err)
+	}
+
+	if got != secret {
+		t.Errorf("Decrypt() = %s, want %s", got, secret)
+	}
+
+	// a random nonce makes every encryption different
+	again, err := Encrypt(key, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Equal(ciphertext, again) {
+		t.Error("Encrypt() returned the same ciphertext twice")
+	}
+}
+
+func TestDecryptRejectsTamperedCiphertext(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+
+	ciphertext, err := Encrypt(key, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tampered := bytes.Clone(ciphertext)
+	tampered[len(tampered)-1] ^= 0x01
+
+	tests := []struct {
+		name       string
+		key        []byte
+		ciphertext []byte
+	}{
+		{"tampered ciphertext", key, tampered},
+		{"wrong key", bytes.Repeat([]byte{2}, 32), ciphertext},
+		{"shorter than the nonce", key, ciphertext[:4]},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Decrypt(tt.key, tt.ciphertext); !errors.Is(err, ErrInvalidCiphertext) {
+				t.Errorf("Decrypt() error = %v, want %v", err, ErrInvalidCiphertext)
+			}
+		})
+	}
+}
+
+func TestNormalizeRecoveryCode(t *testing.T) {
+	tests := []struct {
+		code string
+		want string
+	}{
+		{"ABCDEFGH", "ABCDEFGH"},
+		{"abcdefgh", "ABCDEFGH"},
+		{"abcd-efgh", "ABCDEFGH"},
+		{" abcd efgh ", "ABCDEFGH"},
+		{"ab-cd ef-gh", "ABCDEFGH"},
+	}
+
+	for _, tt := range tests {
+		if got := NormalizeRecoveryCode(tt.code); got != tt.want {
+			t.Errorf("NormalizeRecoveryCode(%q) = %q, want %q", tt.code, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS jwt_denylist (
+    jti text PRIMARY KEY,
+    expiry timestamp(0) with time zone NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS jwt_denylist_expiry_idx ON jwt_denylist (expiry);
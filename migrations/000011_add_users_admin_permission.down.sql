@@ -0,0 +1,2 @@
+DELETE FROM roles WHERE code = 'admin';
+DELETE FROM permissions WHERE code = 'users:admin';
@@ -0,0 +1,11 @@
+INSERT INTO permissions (code)
+VALUES 
+    ('users:admin');
+
+INSERT INTO roles (code)
+VALUES 
+    ('admin');
+
+INSERT INTO roles_permissions
+SELECT roles.id, permissions.id FROM roles, permissions
+WHERE roles.code = 'admin' AND permissions.code IN ('example:read', 'example:write', 'users:admin');
@@ -0,0 +1,5 @@
+DELETE FROM permissions WHERE code = 'example:admin';
+
+DROP INDEX IF EXISTS examples_owner_id_idx;
+
+ALTER TABLE examples DROP COLUMN IF EXISTS owner_id;
@@ -0,0 +1,11 @@
+ALTER TABLE examples ADD COLUMN IF NOT EXISTS owner_id bigint REFERENCES users ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS examples_owner_id_idx ON examples (owner_id);
+
+INSERT INTO permissions (code)
+VALUES 
+    ('example:admin');
+
+INSERT INTO roles_permissions
+SELECT roles.id, permissions.id FROM roles, permissions
+WHERE roles.code = 'admin' AND permissions.code = 'example:admin';
@@ -0,0 +1,10 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deleted_at timestamp(0) with time zone;
+ALTER TABLE examples ADD COLUMN IF NOT EXISTS deleted_at timestamp(0) with time zone;
+
+-- a deleted user must not block its email, the uniqueness only applies to the users that are not deleted
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_email_key;
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_key ON users (email) WHERE deleted_at IS NULL;
+
+-- used by the purger to find the deleted rows
+CREATE INDEX IF NOT EXISTS users_deleted_at_idx ON users (deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS examples_deleted_at_idx ON examples (deleted_at) WHERE deleted_at IS NOT NULL;
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS examples_search_idx;
+
+ALTER TABLE examples DROP COLUMN IF EXISTS search;
@@ -0,0 +1,5 @@
+-- full-text search over example_value_3, kept up to date by postgres
+ALTER TABLE examples ADD COLUMN IF NOT EXISTS search tsvector
+    GENERATED ALWAYS AS (to_tsvector('simple', example_value_3)) STORED;
+
+CREATE INDEX IF NOT EXISTS examples_search_idx ON examples USING GIN (search);
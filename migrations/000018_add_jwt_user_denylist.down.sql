@@ -0,0 +1,8 @@
+DROP TRIGGER IF EXISTS jwt_user_denylist_changed ON jwt_user_denylist;
+DROP TRIGGER IF EXISTS jwt_denylist_changed ON jwt_denylist;
+DROP TRIGGER IF EXISTS users_jwts_denied ON users;
+
+DROP FUNCTION IF EXISTS notify_jwt_denylist_changed();
+DROP FUNCTION IF EXISTS deny_user_jwts();
+
+DROP TABLE IF EXISTS jwt_user_denylist;
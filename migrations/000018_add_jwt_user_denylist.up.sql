@@ -0,0 +1,44 @@
+-- the JWTs of an user issued before denied_at are denied, the user has been deleted or deactivated
+CREATE TABLE IF NOT EXISTS jwt_user_denylist (
+    user_id bigint PRIMARY KEY REFERENCES users ON DELETE CASCADE,
+    denied_at timestamp(6) with time zone NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS jwt_user_denylist_denied_at_idx ON jwt_user_denylist (denied_at);
+
+-- deny the JWTs of the users that are deleted or deactivated, whatever changed them,
+-- and revoke their refresh tokens so no new JWTs are issued
+CREATE OR REPLACE FUNCTION deny_user_jwts() RETURNS trigger AS $$
+BEGIN
+    INSERT INTO jwt_user_denylist (user_id, denied_at)
+    VALUES (NEW.id, NOW())
+    ON CONFLICT (user_id) DO UPDATE SET denied_at = EXCLUDED.denied_at;
+
+    DELETE FROM tokens WHERE user_id = NEW.id AND scope IN ('authentication', 'refresh');
+
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER users_jwts_denied
+AFTER UPDATE OF deleted_at, activated ON users
+FOR EACH ROW
+WHEN ((OLD.deleted_at IS NULL AND NEW.deleted_at IS NOT NULL) OR (OLD.activated AND NOT NEW.activated))
+EXECUTE FUNCTION deny_user_jwts();
+
+-- notify the denylist changes, so API instances can reload their denylist cache
+CREATE OR REPLACE FUNCTION notify_jwt_denylist_changed() RETURNS trigger AS $$
+BEGIN
+    PERFORM pg_notify('jwt_denylist_changed', '');
+
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER jwt_denylist_changed
+AFTER INSERT OR UPDATE ON jwt_denylist
+FOR EACH STATEMENT EXECUTE FUNCTION notify_jwt_denylist_changed();
+
+CREATE TRIGGER jwt_user_denylist_changed
+AFTER INSERT OR UPDATE ON jwt_user_denylist
+FOR EACH STATEMENT EXECUTE FUNCTION notify_jwt_denylist_changed();